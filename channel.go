@@ -0,0 +1,103 @@
+package llk
+
+import (
+	"llk/types"
+)
+
+// channelTokeniser implements the Tokeniser interface over tokens
+// received from a channel, such as from a lexer running in a goroutine
+// of its own. Received tokens are buffered in tokens so the parse can
+// backtrack over them
+type channelTokeniser struct {
+	ch <-chan types.Token
+
+	// tokens is the sequence of tokens received so far
+	tokens []types.Token
+
+	// loc is the "current" location of the tokeniser
+	loc int
+
+	// env is the user defined environment of the parse
+	env any
+
+	// err is ErrEOF once the channel is closed
+	err error
+}
+
+// NewChannelTokeniser returns a Tokeniser over the tokens received from
+// ch, bypassing the scanner entirely so that any lexer can be used. A
+// token is only received once the parse needs it, and the channel being
+// closed marks the end of the input. The location of each token is set
+// as it is received, the rest of its position is left as sent
+func NewChannelTokeniser(ch <-chan types.Token) *channelTokeniser {
+	return &channelTokeniser{ch: ch}
+}
+
+// WithEnv sets the user defined environment of the parse to env
+func (t *channelTokeniser) WithEnv(env any) *channelTokeniser {
+	t.env = env
+	return t
+}
+
+func (t *channelTokeniser) Loc() int {
+	return t.loc
+}
+
+func (t *channelTokeniser) Dec() {
+	t.loc--
+}
+
+func (t *channelTokeniser) Inc() {
+	t.loc++
+}
+
+// Seek moves the tokeniser to the location loc, which has to be within
+// the tokens already received
+func (t *channelTokeniser) Seek(loc int) {
+	if loc < 0 || loc > len(t.tokens) {
+		panic(types.ErrBadLoc)
+	}
+	t.loc = loc
+}
+
+// Peek returns the Token at the current location, receiving it from the
+// channel if it has not been received already
+func (t *channelTokeniser) Peek() (token types.Token, ok bool) {
+	if t.loc >= len(t.tokens) && t.err == nil {
+		token, ok := <-t.ch
+		if !ok {
+			t.err = types.ErrEOF
+		} else {
+			pos := token.Pos()
+			pos.Loc = len(t.tokens)
+			t.tokens = append(t.tokens, token.WithPos(pos))
+		}
+	}
+	if t.loc >= len(t.tokens) {
+		return
+	}
+	return t.tokens[t.loc], true
+}
+
+func (t *channelTokeniser) Env() any {
+	return t.env
+}
+
+// Err returns ErrEOF once the channel has been closed, and nil before
+func (t *channelTokeniser) Err() error {
+	return t.err
+}
+
+// PushWhitespace does nothing, the lexer sending the tokens decides
+// which whitespace is significant
+func (*channelTokeniser) PushWhitespace(uint64) {}
+
+// PopWhitespace does nothing, see PushWhitespace
+func (*channelTokeniser) PopWhitespace() {}
+
+// PushLexer does nothing, the lexer sending the tokens decides how the
+// input is scanned
+func (*channelTokeniser) PushLexer(*types.Lexer) {}
+
+// PopLexer does nothing, see PushLexer
+func (*channelTokeniser) PopLexer() {}
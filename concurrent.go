@@ -0,0 +1,52 @@
+package llk
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"llk/types"
+)
+
+// ParseConcurrent applies the parser p to each of segments, independent
+// pieces of one input such as the lines of newline delimited JSON, with
+// at most workers of them being parsed at once, and returns the result
+// for each segment in the order of segments. Each segment is read in
+// full and given a tokeniser of its own, positions are relative to the
+// segment. The parses share p, so p must be safe to use from more than
+// one goroutine, which it is unless it calls code of its own which is
+// not. The error is that of the first segment which could not be read
+func ParseConcurrent(segments []io.Reader, p Chain, workers int) ([]types.Result, error) {
+	var (
+		results = make([]types.Result, len(segments))
+		errs    = make([]error, len(segments))
+		next    = make(chan int)
+		wg      sync.WaitGroup
+	)
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				b, err := io.ReadAll(segments[i])
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				results[i] = p.Parse(NewTokeniser(strings.NewReader(string(b))))
+			}
+		}()
+	}
+	for i := range segments {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
@@ -1,10 +1,12 @@
 package examples
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
 	"llk"
+	"llk/types"
 )
 
 func TestArithmetic(t *testing.T) {
@@ -43,5 +45,343 @@ func TestArithmetic(t *testing.T) {
 		EitherInt("expr").
 		Chain(subexpr)
 
-	t.Error(expr.Parse(tokeniser))
+	if v := expr.Parse(tokeniser).Value(); v != int64(19) {
+		t.Errorf("expected 19, got %v", v)
+	}
+}
+
+// binary returns a parser of the operator c whose value is the
+// function f applying it
+func binary(c rune, f func(a, b int64) int64) llk.Parser {
+	return llk.SeqText("", c).Return(func(any) any {
+		return func(a, b any) any {
+			return f(a.(int64), b.(int64))
+		}
+	})
+}
+
+func TestChainl(t *testing.T) {
+	minus := binary('-', func(a, b int64) int64 { return a - b })
+	p := llk.Chainl("difference", types.Int(), minus)
+
+	if r := p.Parse(llk.NewTokeniser(strings.NewReader("1 - 2 - 3"))); r.Value() != int64(-4) {
+		t.Errorf("expected (1 - 2) - 3 = -4, got %v", r)
+	}
+	if r := p.Parse(llk.NewTokeniser(strings.NewReader("7"))); r.Value() != int64(7) {
+		t.Errorf("expected a lone operand, got %v", r)
+	}
+}
+
+func TestChainr(t *testing.T) {
+	pow := binary('^', func(a, b int64) int64 {
+		v := int64(1)
+		for ; b > 0; b-- {
+			v *= a
+		}
+		return v
+	})
+	p := llk.Chainr("power", types.Int(), pow)
+
+	if r := p.Parse(llk.NewTokeniser(strings.NewReader("2 ^ 3 ^ 2"))); r.Value() != int64(512) {
+		t.Errorf("expected 2 ^ (3 ^ 2) = 512, got %v", r)
+	}
+
+	// an operand which finishes at more than one location, either
+	// after an integer or after a suffix multiplying it by ten, is
+	// folded along each path
+	ten := llk.Seq("", types.Int()).Passthrough(types.Id("i")).Return(func(v any) any {
+		return v.(int64) * 10
+	})
+	operand := llk.Either("", types.Int()).Chain(ten)
+	r := llk.Chainr("power", operand, pow).Parse(llk.NewTokeniser(strings.NewReader("2 ^ 3 i")))
+	s, ok := r.(types.Succeeded)
+	if !ok || len(s.Locs()) != 2 || s.ValueAt(3) != int64(8) || s.ValueAt(4) != int64(1<<30) {
+		t.Errorf("expected 2 ^ 3 = 8 at 3 and 2 ^ 30 at 4, got %v", r)
+	}
+	p = llk.Seq("", llk.Chainr("power", operand, pow)).Passthrough(types.Id("i"))
+	if r := p.Parse(llk.NewTokeniser(strings.NewReader("2 ^ 3 i"))); r.Value() != int64(8) {
+		t.Errorf("expected the path followed by i to fold to 8, got %v", r)
+	}
+
+	// an operator whose value is not a function halts the fold
+	caret := llk.SeqText("", '^')
+	r = llk.Chainr("power", types.Int(), caret).Parse(llk.NewTokeniser(strings.NewReader("2 ^ 3")))
+	if errs := r.Errors(); r.Kind() != types.HaltKind || len(errs) != 1 ||
+		errs[0].Error() != "1:1: fold: operator value ^ is a string, not a func(a, b any) any" {
+		t.Errorf("expected the fold to halt, got %v", r)
+	}
+}
+
+func TestEnd(t *testing.T) {
+	p := llk.Seq("sum", llk.Expression(sum{}, "+-", "*/")).Passthrough(llk.End())
+
+	if r := p.Parse(llk.NewTokeniser(strings.NewReader("1 + 2"))); r.Value() != int64(3) {
+		t.Errorf("expected 3, got %v", r)
+	}
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("1 + 2 )")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "1:7: expected end of input, got )" {
+		t.Errorf("expected the unparsed ) to fail, got %v", errs)
+	}
+}
+
+// sum evaluates expressions of integers
+type sum struct{}
+
+func (sum) OnNumber(n any) any {
+	return n
+}
+
+func (sum) OnGroup(v any) any {
+	return v
+}
+
+func (sum) OnBinaryOp(op string, a, b any) any {
+	switch op {
+	case "+":
+		return a.(int64) + b.(int64)
+	case "-":
+		return a.(int64) - b.(int64)
+	case "*":
+		return a.(int64) * b.(int64)
+	}
+	return a.(int64) / b.(int64)
+}
+
+// infix prints expressions fully parenthesised
+type infix struct{}
+
+func (infix) OnNumber(n any) any {
+	return fmt.Sprint(n)
+}
+
+func (infix) OnGroup(v any) any {
+	return v
+}
+
+func (infix) OnBinaryOp(op string, a, b any) any {
+	return fmt.Sprintf("(%s %s %s)", a, op, b)
+}
+
+func TestExpression(t *testing.T) {
+	input := "1 + 2 * (3 - 1) - 4 / 2"
+
+	p := llk.Expression(sum{}, "+-", "*/")
+	if v := p.Parse(llk.NewTokeniser(strings.NewReader(input))).Value(); v != int64(3) {
+		t.Errorf("expected 3, got %v", v)
+	}
+
+	p = llk.Expression(sum{}, "+-", "", "*/")
+	if v := p.Parse(llk.NewTokeniser(strings.NewReader(input))).Value(); v != int64(3) {
+		t.Errorf("expected an empty level to be skipped, got %v", v)
+	}
+
+	p = llk.Expression(infix{}, "+-", "*/")
+	v := p.Parse(llk.NewTokeniser(strings.NewReader(input))).Value()
+	if want := "((1 + (2 * (3 - 1))) - (4 / 2))"; v != want {
+		t.Errorf("expected %s, got %v", want, v)
+	}
+}
+
+func TestDump(t *testing.T) {
+	var expr llk.Chain
+	subexpr := llk.
+		SeqText("subexpr", '(').
+		Lazy(func(any) llk.Parser {
+			return expr
+		}).
+		Text('+').
+		Lazy(func(any) llk.Parser {
+			return expr
+		}).
+		Text(')')
+	expr = llk.
+		EitherInt("expr").
+		Chain(subexpr)
+
+	want := "expr := integer | subexpr\n" +
+		`subexpr := "(" <lazy> "+" <lazy> ")"`
+	if s := expr.Dump(); s != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, s)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	var expr llk.Chain
+	subexpr := llk.
+		SeqText("subexpr", '(').
+		Lazy(func(any) llk.Parser {
+			return expr
+		}).
+		Text('+').
+		Lazy(func(any) llk.Parser {
+			return expr
+		}).
+		Text(')')
+	expr = llk.
+		EitherInt("expr").
+		Chain(subexpr)
+
+	s := expr.Explain(llk.NewTokeniser(strings.NewReader("(1 + 2)")))
+	var operands []string
+	for _, step := range strings.Split(s, "\n") {
+		if strings.Contains(step, "matched integer") {
+			operands = append(operands, step)
+		}
+	}
+	if got := strings.Join(operands, "; "); got != "at 1:2 matched integer 1; at 1:6 matched integer 2" {
+		t.Errorf("expected the operands in order, got %s", got)
+	}
+	if !strings.HasSuffix(s, "at 1:1 subexpr matched\nat 1:1 expr matched") {
+		t.Errorf("expected the narrative to end with expr matching, got\n%s", s)
+	}
+}
+
+func TestExplainWithin(t *testing.T) {
+	// the steps of a parser reading through a window are part of the
+	// narrative of the parse around it
+	p := llk.Seq("probe", llk.Within(2, llk.SeqInt("operands").Int()))
+
+	s := p.Explain(llk.NewTokeniser(strings.NewReader("1 2")))
+	for _, step := range []string{"at 1:1 tried operands", "at 1:1 matched integer 1", "at 1:3 matched integer 2", "at 1:1 operands matched"} {
+		if !strings.Contains(s, step) {
+			t.Errorf("expected the step %q, got\n%s", step, s)
+		}
+	}
+}
+
+func TestCombine(t *testing.T) {
+	// a host language statement naming the result of an embedded
+	// arithmetic expression
+	p := llk.Combine(types.Ident(), llk.Expression(sum{}, "+-", "*/"), func(name, v any) any {
+		return fmt.Sprintf("%s=%d", name, v)
+	})
+	if r := p.Parse(llk.NewTokeniser(strings.NewReader("total 2 * (3 + 4)"))); r.Value() != "total=14" {
+		t.Errorf("expected total=14, got %v", r)
+	}
+}
+
+func TestExpecting(t *testing.T) {
+	// <sum> → `(` <int> `+` <int> `)`
+	p := llk.SeqText("sum", '(').
+		Int().
+		Text('+').
+		Chain(llk.Expecting("an expression", llk.Seq("", types.Int()).Text(')')))
+
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("(1 +")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "1:5: unexpected end of input; expected an expression" {
+		t.Errorf("expected a missing expression, got %v", errs)
+	}
+	r = p.Parse(llk.NewTokeniser(strings.NewReader("(1\n+")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "2:2: unexpected end of input; expected an expression" {
+		t.Errorf("expected a missing expression on the second line, got %v", errs)
+	}
+
+	r = p.Parse(llk.NewTokeniser(strings.NewReader("(1 + x)")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "expected integer" {
+		t.Errorf("expected an ordinary failure before the end, got %v", errs)
+	}
+
+	// a lookahead which runs into the end of the input and backtracks
+	// does not make a failure before the end a truncation
+	q := llk.Expecting("a value", llk.Seq("", llk.Not("pair", llk.Seq("", types.Ident()).Chain(types.Ident()).Chain(types.Ident()))).Int())
+	r = q.Parse(llk.NewTokeniser(strings.NewReader("a b")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "expected integer" {
+		t.Errorf("expected an ordinary failure before the end, got %v", errs)
+	}
+}
+
+func TestTernary(t *testing.T) {
+	p := llk.Ternary(types.Int(), types.Int(), types.Int(), types.Text('?'), types.Text(':'), func(c, a, b any) any {
+		return fmt.Sprintf("(%v ? %v : %v)", c, a, b)
+	})
+
+	for input, want := range map[string]string{
+		"1 ? 2 : 3":         "(1 ? 2 : 3)",
+		"1 ? 2 : 3 ? 4 : 5": "(1 ? 2 : (3 ? 4 : 5))",
+	} {
+		if v := p.Parse(llk.NewTokeniser(strings.NewReader(input))).Value(); v != want {
+			t.Errorf("%s: expected %s, got %v", input, want, v)
+		}
+	}
+}
+
+func TestNonAssoc(t *testing.T) {
+	less := llk.NonAssoc(types.Ident(), types.Text('<'), func(a, op, b any) any {
+		return fmt.Sprintf("(%v %v %v)", a, op, b)
+	})
+
+	if v := less.Parse(llk.NewTokeniser(strings.NewReader("a < b"))).Value(); v != "(a < b)" {
+		t.Errorf("expected (a < b), got %v", v)
+	}
+	r := less.Parse(llk.NewTokeniser(strings.NewReader("a < b < c")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "1:7: non-associative operator < cannot follow <" {
+		t.Errorf("expected a chained comparison to fail, got %v", errs)
+	}
+}
+
+func TestWithTrivia(t *testing.T) {
+	p := llk.WithTrivia(types.Comment(), llk.Expression(sum{}, "+-", "*/"))
+
+	tokeniser := llk.NewTokeniser(strings.NewReader("1 /*c*/ + 2")).WithComments()
+	if r := p.Parse(tokeniser); r.Value() != int64(3) {
+		t.Errorf("expected the comment to be skipped, got %v", r)
+	}
+
+	// peeking past trivia does not move the tokeniser
+	peek := llk.WithTrivia(types.Comment(), types.Func(func(t types.Tokeniser) types.Result {
+		token, _ := t.Peek()
+		return types.NewSucceeded(token.Match(), t.Loc())
+	}))
+	tokeniser = llk.NewTokeniser(strings.NewReader("/*c*/ 1")).WithComments()
+	if r := peek.Parse(tokeniser); r.Value() != "1" || tokeniser.Loc() != 0 {
+		t.Errorf("expected to peek at 1 without moving, got %v at %d", r, tokeniser.Loc())
+	}
+}
+
+func TestPrecedenceClimb(t *testing.T) {
+	ops := func(op string) (int, bool, func(a, b any) any) {
+		switch op {
+		case "+":
+			return 1, false, func(a, b any) any { return a.(int64) + b.(int64) }
+		case "-":
+			return 1, false, func(a, b any) any { return a.(int64) - b.(int64) }
+		case "*":
+			return 2, false, func(a, b any) any { return a.(int64) * b.(int64) }
+		}
+		return 0, false, nil
+	}
+	p := llk.PrecedenceClimb(types.Int(), ops)
+
+	if r := p.Parse(llk.NewTokeniser(strings.NewReader("2 + 3 * 4 - 1"))); r.Value() != int64(13) {
+		t.Errorf("expected 13, got %v", r)
+	}
+	if r := p.Parse(llk.NewTokeniser(strings.NewReader("10 - 2 - 3"))); r.Value() != int64(5) {
+		t.Errorf("expected left associative subtraction, got %v", r)
+	}
+}
+
+func TestBetween(t *testing.T) {
+	group := llk.Between("group", types.Text('('), llk.Expression(sum{}, "+-", "*/"), types.Text(')'))
+
+	if r := group.Parse(llk.NewTokeniser(strings.NewReader("(1 + 2)"))); r.Value() != int64(3) {
+		t.Errorf("expected 3, got %v", r)
+	}
+	r := group.Parse(llk.NewTokeniser(strings.NewReader("\n  (1 + 2")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "expected text, to close ( opened at 2:3" {
+		t.Errorf("expected an unmatched (, got %v", errs)
+	}
+	r = group.Parse(llk.NewTokeniser(strings.NewReader("(1 + 2]")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "1:7: expected text, to close ( opened at 1:1" {
+		t.Errorf("expected an unmatched (, got %v", errs)
+	}
+	r = group.Parse(llk.NewTokeniser(strings.NewReader("()")))
+	if errs := r.Errors(); len(errs) == 0 || strings.Contains(errs[0].Error(), "to close") {
+		t.Errorf("expected a failure inside the group to be left alone, got %v", errs)
+	}
+
+	list := llk.Between("list", types.Text('['), types.Int(), types.Text(']'))
+	if s := list.Dump(); s != `start := list
+list := "[" integer "]"` {
+		t.Errorf("expected the grammar of the list, got\n%s", s)
+	}
 }
@@ -0,0 +1,282 @@
+package examples
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"text/scanner"
+
+	"llk"
+	"llk/types"
+)
+
+func TestFactor(t *testing.T) {
+	var calls int
+	name := types.Func(func(t types.Tokeniser) types.Result {
+		calls++
+		return types.Id("f").Parse(t)
+	})
+	call := llk.SeqText("", '(').Text(')')
+	assign := llk.SeqText("", '=').Chain(types.Int())
+
+	unfactored := llk.Either("", llk.Seq("", name).Chain(call)).
+		Chain(llk.Seq("", name).Chain(assign))
+	if r := unfactored.Parse(llk.NewTokeniser(strings.NewReader("f = 1"))); r.Value() != int64(1) {
+		t.Fatalf("expected 1, got %v", r)
+	}
+	if calls != 2 {
+		t.Fatalf("expected the unfactored prefix to be parsed twice, got %d", calls)
+	}
+
+	calls = 0
+	factored := llk.Factor(name, call, assign)
+	if r := factored.Parse(llk.NewTokeniser(strings.NewReader("f = 1"))); r.Value() != int64(1) {
+		t.Errorf("expected 1, got %v", r)
+	}
+	if calls != 1 {
+		t.Errorf("expected the factored prefix to be parsed once, got %d", calls)
+	}
+}
+
+func TestPermutation(t *testing.T) {
+	attr := func(name string) llk.Chain {
+		return llk.SeqId(name, name).Text('=').Chain(types.Int())
+	}
+	p := llk.Permutation("attrs", attr("width"), attr("height"))
+
+	for _, s := range []string{"width=1 height=2", "height=2 width=1"} {
+		r := p.Parse(llk.NewTokeniser(strings.NewReader(s)))
+		vs, ok := r.Value().([]any)
+		if !ok || len(vs) != 2 || vs[0] != int64(1) || vs[1] != int64(2) {
+			t.Errorf("expected %q to parse as [1 2], got %v", s, r)
+		}
+	}
+
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("width=1")))
+	if len(r.Errors()) == 0 || r.Errors()[0].Error() != "expected height" {
+		t.Errorf("expected a missing height error, got %v", r)
+	}
+
+	r = p.Parse(llk.NewTokeniser(strings.NewReader("width=1 width=2 height=3")))
+	if len(r.Errors()) == 0 {
+		t.Errorf("expected a duplicate width error, got %v", r)
+	}
+}
+
+func TestStrictAmbiguity(t *testing.T) {
+	// ambiguous parser for the grammar:
+	//
+	//	<a> → `a` | `a` `b`
+	a := func() llk.Chain {
+		return llk.EitherId("a", "a").Chain(llk.SeqId("", "a").Id("b"))
+	}
+
+	r := a().Parse(llk.NewTokeniser(strings.NewReader("a b")))
+	if len(r.Locs()) != 2 {
+		t.Fatalf("expected an ambiguous result, got %v", r)
+	}
+
+	r = a().Strict().Parse(llk.NewTokeniser(strings.NewReader("a b")))
+	h, ok := r.(types.Halt)
+	if !ok || h.Component() != "ambiguity" || !errors.Is(h.Err(), types.ErrAmbiguous) {
+		t.Fatalf("expected an ambiguity halt, got %v", r)
+	}
+	if !strings.HasSuffix(h.Error(), "a finishes at locations 1, 2") {
+		t.Errorf("unexpected halt message %q", h.Error())
+	}
+
+	r = a().Strict().Parse(llk.NewTokeniser(strings.NewReader("a c")))
+	if _, ok := r.(types.Succeeded); !ok {
+		t.Errorf("expected an unambiguous success, got %v", r)
+	}
+
+	// the ambiguity of a nested parser is not hidden by the parsers
+	// which follow it finishing at a single location
+	root := llk.Seq("root", a()).Text(';')
+	if r := root.Parse(llk.NewTokeniser(strings.NewReader("a b ;"))); r.Kind() != types.SucceededKind {
+		t.Fatalf("expected the root to succeed without strict mode, got %v", r)
+	}
+	r = root.Strict().Parse(llk.NewTokeniser(strings.NewReader("a b ;")))
+	h, ok = r.(types.Halt)
+	if !ok || !strings.HasSuffix(h.Error(), "a finishes at locations 1, 2") {
+		t.Errorf("expected the nested ambiguity to halt, got %v", r)
+	}
+}
+
+func TestSuffixes(t *testing.T) {
+	type (
+		array    struct{ elem any }
+		nullable struct{ elem any }
+	)
+	apply := func(v, suffix any) any {
+		if suffix == "[" {
+			return array{v}
+		}
+		return nullable{v}
+	}
+	p := llk.Suffixes(
+		types.Ident(), apply, llk.SeqText("", '[').Text(']'), types.Text('?'),
+	)
+
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("int[]?")))
+	if r.Value() != (nullable{array{"int"}}) {
+		t.Errorf("expected a nullable array of int, got %v", r.Value())
+	}
+
+	r = p.Parse(llk.NewTokeniser(strings.NewReader("int?[]")))
+	if r.Value() != (array{nullable{"int"}}) {
+		t.Errorf("expected an array of nullable int, got %v", r.Value())
+	}
+}
+
+func TestTagged(t *testing.T) {
+	type (
+		circle struct{ radius int64 }
+		square struct{ side int64 }
+	)
+	p := llk.Tagged(types.String(), map[any]types.Parser{
+		"circle": llk.SeqInt("").Return(func(v any) any {
+			return circle{v.(int64)}
+		}),
+		"square": llk.SeqInt("").Return(func(v any) any {
+			return square{v.(int64)}
+		}),
+	})
+
+	r := p.Parse(llk.NewTokeniser(strings.NewReader(`"circle" 2`)))
+	if r.Value() != (circle{2}) {
+		t.Errorf("expected a circle, got %v", r)
+	}
+	r = p.Parse(llk.NewTokeniser(strings.NewReader(`"square" 3`)))
+	if r.Value() != (square{3}) {
+		t.Errorf("expected a square, got %v", r)
+	}
+
+	r = p.Parse(llk.NewTokeniser(strings.NewReader(`"triangle" 4`)))
+	if len(r.Errors()) == 0 || r.Errors()[0].Error() != "expected one of circle, square" {
+		t.Errorf("expected an unknown tag error, got %v", r)
+	}
+}
+
+func TestRace(t *testing.T) {
+	// run with -race, each parser reads its own snapshot of the input
+	call := llk.Seq("call", types.Ident()).Text('(').Text(')').
+		Return(func(v any) any {
+			return "call " + v.(string)
+		})
+	index := llk.Seq("index", types.Ident()).Text('[').Int().Text(']').
+		Return(func(v any) any {
+			return "index " + v.(string)
+		})
+
+	p := llk.Seq("", llk.Race(call, index)).Text(';')
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("a[3];")))
+	if r.Value() != "index a" {
+		t.Errorf("expected the index to win, got %v", r)
+	}
+
+	r = p.Parse(llk.NewTokeniser(strings.NewReader("a{3};")))
+	if len(r.Errors()) != 2 {
+		t.Errorf("expected the failures of both, got %v", r)
+	}
+}
+
+func TestMaxAmbiguity(t *testing.T) {
+	// a list of one, two or three identifiers has three interpretations
+	// of the input a b c
+	id := types.Ident()
+	p := llk.Either("", llk.Seq("", id)).
+		Chain(llk.Seq("", id).Chain(id)).
+		Chain(llk.Seq("", id).Chain(id).Chain(id))
+
+	r := llk.MaxAmbiguity(3, p).Parse(llk.NewTokeniser(strings.NewReader("a b c")))
+	if len(r.Locs()) != 3 {
+		t.Errorf("expected three interpretations, got %v", r)
+	}
+
+	r = llk.MaxAmbiguity(2, p).Parse(llk.NewTokeniser(strings.NewReader("a b c")))
+	if h, ok := r.(types.Halt); !ok || !errors.Is(h.Err(), types.ErrAmbiguous) {
+		t.Errorf("expected an ambiguity halt, got %v", r)
+	}
+}
+
+func TestCut(t *testing.T) {
+	// <stmt> → `let` <ident> `=` <int> | <ident> `(` `)`
+	stmt := func(let llk.Chain) llk.Chain {
+		call := llk.Seq("call", types.Ident()).Text('(').Text(')')
+		return llk.Either("statement", llk.Seq("let", types.Id("let")).Chain(let)).Chain(call)
+	}
+	assign := llk.Seq("", types.Ident()).Text('=').Int()
+
+	// without the cut the call alternative is tried too, since let is
+	// also an identifier, and its error is reported alongside
+	r := stmt(assign).Parse(llk.NewTokeniser(strings.NewReader("let = 1")))
+	if len(r.Errors()) != 2 {
+		t.Errorf("expected the errors of both alternatives, got %v", r.Errors())
+	}
+
+	r = stmt(llk.Cut(assign)).Parse(llk.NewTokeniser(strings.NewReader("let = 1")))
+	if _, ok := r.(types.Failed); !ok {
+		t.Fatalf("expected the cut to stop at the alternation, got %v", r)
+	}
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "expected identifier" {
+		t.Errorf("expected only the error after the cut, got %v", errs)
+	}
+
+	// with no alternation to stop at, the cut fails the parse
+	r = llk.Seq("let", types.Id("let")).Chain(llk.Cut(assign)).Parse(llk.NewTokeniser(strings.NewReader("let = 1")))
+	if _, ok := r.(types.Failed); !ok || len(r.Errors()) != 1 {
+		t.Errorf("expected the cut to fail the parse, got %v", r)
+	}
+}
+
+func TestLLk(t *testing.T) {
+	// an assignment and a call both begin with an identifier, the second
+	// token decides between them
+	assign := llk.Seq("", types.Ident()).Text('=').Int().Return(func(v any) any {
+		return "assign " + v.(string)
+	})
+	call := llk.Seq("", types.Ident()).Text('(').Text(')').Return(func(v any) any {
+		return "call " + v.(string)
+	})
+	p := llk.LLk(2,
+		llk.Lookahead{Prefix: []rune{scanner.Ident, '='}, Parser: assign},
+		llk.Lookahead{Prefix: []rune{scanner.Ident, '('}, Parser: call},
+	)
+
+	for input, want := range map[string]string{"x = 1": "assign x", "f()": "call f"} {
+		if r := p.Parse(llk.NewTokeniser(strings.NewReader(input))); r.Value() != want {
+			t.Errorf("expected %s, got %v", want, r)
+		}
+	}
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("x + 1")))
+	if len(r.Errors()) != 1 || r.Errors()[0].Error() != `expected one of Ident "=", Ident "("` {
+		t.Errorf("expected no branch to match, got %v", r)
+	}
+}
+
+func TestChoice(t *testing.T) {
+	p := llk.Choice("literal", types.Int(), types.Float(), types.String())
+
+	for input, want := range map[string]any{"1": int64(1), "1.5": 1.5, `"a"`: "a"} {
+		if r := p.Parse(llk.NewTokeniser(strings.NewReader(input))); r.Value() != want {
+			t.Errorf("%s: expected %v, got %v", input, want, r)
+		}
+	}
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("x")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "expected one of: integer, float, quoted string" {
+		t.Errorf("expected the alternatives to be aggregated, got %v", errs)
+	}
+
+	halt := types.Func(func(types.Tokeniser) types.Result {
+		return types.NewHalt("test", errors.New("stop"), types.Pos{})
+	})
+	if r := llk.Choice("", halt, types.Ident()).Parse(llk.NewTokeniser(strings.NewReader("x"))); r.Kind() != types.HaltKind {
+		t.Errorf("expected the halt to short circuit, got %v", r)
+	}
+
+	r = llk.Choice("literal").Parse(llk.NewTokeniser(strings.NewReader("x")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "1:1: literal has no alternatives" {
+		t.Errorf("expected an empty choice to fail, got %v", errs)
+	}
+}
@@ -0,0 +1,73 @@
+package examples
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"llk"
+	"llk/types"
+)
+
+func TestCompile(t *testing.T) {
+	// grammar for an assignment of integers in any number of parentheses
+	//
+	//	<stmt> → <ident> `=` <value>
+	//	<value> → <int> | `(` <value> `)`
+	text := func(s string) llk.Rule {
+		return llk.Rule{Kind: llk.TermRule, Name: "text", Match: s}
+	}
+	g := llk.Grammar{
+		Start: "stmt",
+		Rules: map[string]llk.Rule{
+			"stmt": {Kind: llk.SeqRule, Rules: []llk.Rule{
+				{Kind: llk.TermRule, Name: "ident"},
+				text("="),
+				{Kind: llk.RefRule, Name: "value"},
+			}},
+			"value": {Kind: llk.EitherRule, Rules: []llk.Rule{
+				{Kind: llk.TermRule, Name: "int"},
+				{Kind: llk.SeqRule, Rules: []llk.Rule{
+					text("("),
+					{Kind: llk.RefRule, Name: "value"},
+					text(")"),
+				}},
+			}},
+		},
+	}
+	p, err := llk.Compile(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("x = ((42))")))
+	if v := fmt.Sprint(r.Value()); v != "[x = [( [( 42 )] )]]" {
+		t.Errorf("expected the nested values, got %v", r)
+	}
+
+	g.Rules["stmt"].Rules[2].Name = "values"
+	if _, err := llk.Compile(g); err == nil || err.Error() != "rule stmt: undefined rule values" {
+		t.Errorf("expected an undefined rule error, got %v", err)
+	}
+}
+
+func TestRuleSet(t *testing.T) {
+	// mutually recursive rules
+	//
+	//	<a> → `(` <b> `)` | <x>
+	//	<b> → `[` <a> `]` | <y>
+	rules := llk.RuleSet{}
+	rules["a"] = llk.Either("a", llk.SeqText("", '(').Chain(rules.Ref("b")).Text(')')).
+		Chain(types.Id("x"))
+	rules["b"] = llk.Either("b", llk.SeqText("", '[').Chain(rules.Ref("a")).Text(']')).
+		Chain(types.Id("y"))
+
+	r := rules.Ref("a").Parse(llk.NewTokeniser(strings.NewReader("([([x])])")))
+	if _, ok := r.Locs()[9]; !ok || r.Value() != "x" {
+		t.Errorf("expected the nested x, got %v", r)
+	}
+
+	r = rules.Ref("c").Parse(llk.NewTokeniser(strings.NewReader("x")))
+	if h, ok := r.(types.Halt); !ok || h.Err().Error() != "undefined rule c" {
+		t.Errorf("expected an undefined rule to halt, got %v", r)
+	}
+}
@@ -0,0 +1,115 @@
+package examples
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"text/scanner"
+
+	"llk"
+	"llk/types"
+)
+
+func TestRecoverFollow(t *testing.T) {
+	// statement parser for the grammar:
+	//
+	//	<stmts> → { <assign> `;` }
+	//	<assign> → <ident> `=` <int>
+	//
+	// where the FOLLOW set of <assign> is `;`
+	assign := llk.Seq("assign", types.Ident()).
+		Text('=').
+		Chain(types.Int()).
+		Follow(';')
+	stmts := llk.Many("stmts", llk.Recover(assign).Text(';'))
+
+	tokeniser := llk.NewTokeniser(strings.NewReader("x = 1; y = = 2; z = 3;"))
+	r := stmts.Parse(tokeniser)
+	vs, ok := r.Value().([]any)
+	if !ok || len(vs) != 3 || vs[0] != int64(1) || vs[2] != int64(3) {
+		t.Fatalf("expected [1 <failed> 3], got %v", r.Value())
+	}
+	if f, ok := vs[1].(types.Failed); !ok || len(f.Errors()) == 0 {
+		t.Errorf("expected the second statement to fail, got %v", vs[1])
+	}
+	if _, ok := r.Locs()[13]; !ok {
+		t.Errorf("expected all statements to be consumed, got %v", r.Locs())
+	}
+}
+
+func TestRecords(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("1 2\n3 x 4\n5 6")).
+		WithWhitespace(scanner.GoWhitespace &^ (1 << '\n'))
+
+	row := llk.SeqInt("row").Lazy(func(a any) llk.Parser {
+		return llk.SeqInt("").Return(func(b any) any {
+			return [2]any{a, b}
+		})
+	})
+	r := llk.Records("records", row, types.Text('\n')).Parse(tokeniser)
+	vs, ok := r.Value().([]any)
+	if !ok || len(vs) != 3 ||
+		vs[0] != [2]any{int64(1), int64(2)} || vs[2] != [2]any{int64(5), int64(6)} {
+		t.Fatalf("expected [[1 2] <failed> [5 6]], got %v", r.Value())
+	}
+	if f, ok := vs[1].(types.Failed); !ok || len(f.Errors()) == 0 {
+		t.Errorf("expected the second row to fail, got %v", vs[1])
+	}
+}
+
+func TestRecordsEnd(t *testing.T) {
+	records := llk.Records("records", types.Ident(), types.Text('\n'))
+	for input, want := range map[string]string{"": "[]", "a\nb\n": "[a b]"} {
+		tokeniser := llk.NewTokeniser(strings.NewReader(input)).
+			WithWhitespace(scanner.GoWhitespace &^ (1 << '\n'))
+		if r := records.Parse(tokeniser); fmt.Sprint(r.Value()) != want {
+			t.Errorf("%q: expected %s, got %v", input, want, r)
+		}
+	}
+}
+
+func TestRecordsBlank(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("1 2\n\n3 4\n5 6")).
+		WithWhitespace(scanner.GoWhitespace &^ (1 << '\n'))
+
+	row := llk.Seq("row", types.Int()).Chain(types.Int())
+	r := llk.Records("records", row, types.Text('\n')).Parse(tokeniser)
+	vs, ok := r.Value().([]any)
+	if !ok || len(vs) != 4 || vs[2] != int64(4) || vs[3] != int64(6) {
+		t.Fatalf("expected the rows after the blank line to be parsed, got %v", r)
+	}
+	if f, ok := vs[1].(types.Failed); !ok || len(f.Errors()) == 0 {
+		t.Errorf("expected the blank row to fail, got %v", vs[1])
+	}
+	if _, ok := r.Locs()[9]; !ok {
+		t.Errorf("expected all rows to be consumed, got %v", r.Locs())
+	}
+}
+
+func TestProgram(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("a = 1; b = ; c = 3"))
+
+	assign := llk.Seq("assign", types.Ident()).Text('=').Chain(types.Int())
+	r := llk.Program(assign, types.Text(';')).Parse(tokeniser)
+	s, ok := r.Value().(llk.Statements)
+	if !ok || len(s.Stmts) != 2 || s.Stmts[0] != int64(1) || s.Stmts[1] != int64(3) {
+		t.Fatalf("expected the statements [1 3], got %v", r.Value())
+	}
+	if len(s.Errors) != 1 || s.Errors[0].Errors()[0].Error() != "expected integer" {
+		t.Errorf("expected a single error for the second statement, got %v", s.Errors)
+	}
+}
+
+func TestProgramEmptyStatement(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("let a;; let c;"))
+
+	let := llk.Seq("let", types.Id("let")).Chain(types.Ident())
+	r := llk.Seq("", llk.Program(let, types.Text(';'))).Passthrough(llk.End()).Parse(tokeniser)
+	s, ok := r.Value().(llk.Statements)
+	if !ok || len(s.Stmts) != 2 || s.Stmts[0] != "a" || s.Stmts[1] != "c" {
+		t.Fatalf("expected the statements [a c], got %v", r)
+	}
+	if len(s.Errors) != 1 {
+		t.Errorf("expected a single error for the empty statement, got %v", s.Errors)
+	}
+}
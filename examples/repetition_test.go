@@ -0,0 +1,267 @@
+package examples
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"text/scanner"
+
+	"llk"
+	"llk/types"
+)
+
+func TestManyEmptyInput(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader(""))
+
+	r := llk.Many("ints", types.Int()).Parse(tokeniser)
+	if _, ok := r.(types.Succeeded); !ok {
+		t.Fatalf("expected success, got %v", r)
+	}
+	if vs, ok := r.Value().([]any); !ok || len(vs) != 0 {
+		t.Errorf("expected an empty slice, got %v", r.Value())
+	}
+
+	// a required integer at the end of the input still fails
+	tokeniser = llk.NewTokeniser(strings.NewReader(""))
+	if r := llk.SeqInt("int").Parse(tokeniser); len(r.Errors()) == 0 {
+		t.Errorf("expected failure, got %v", r)
+	}
+}
+
+func TestMany(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("1 2 3"))
+
+	r := llk.Many("ints", types.Int()).Parse(tokeniser)
+	vs, ok := r.Value().([]any)
+	if !ok || len(vs) != 3 || vs[0] != int64(1) || vs[2] != int64(3) {
+		t.Errorf("expected [1 2 3], got %v", r.Value())
+	}
+}
+
+func TestWithin(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("1 2 3"))
+
+	r := llk.Within(2, llk.Many("ints", types.Int())).Parse(tokeniser)
+	if vs, ok := r.Value().([]any); !ok || len(vs) != 2 {
+		t.Errorf("expected [1 2], got %v", r.Value())
+	}
+	if _, ok := r.Locs()[2]; !ok || len(r.Locs()) != 1 {
+		t.Errorf("expected to finish at location 2, got %v", r.Locs())
+	}
+
+	tokeniser = llk.NewTokeniser(strings.NewReader("1 2 3"))
+	p := llk.Within(2, llk.SeqInt("").Int().Int())
+	if r := p.Parse(tokeniser); len(r.Errors()) == 0 {
+		t.Errorf("expected failure reading past the window, got %v", r)
+	}
+}
+
+func TestSepByAny(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("1, 2\n3")).
+		WithWhitespace(scanner.GoWhitespace &^ (1 << '\n'))
+
+	p := llk.SepByAny("list", types.Int(), types.Text(','), types.Text('\n'))
+	r := p.Parse(tokeniser)
+	vs, ok := r.Value().([]any)
+	if !ok || len(vs) != 3 || vs[0] != int64(1) || vs[1] != int64(2) || vs[2] != int64(3) {
+		t.Errorf("expected [1 2 3], got %v", r.Value())
+	}
+}
+
+func TestSepByN(t *testing.T) {
+	// the arguments of a call taking one to three of them
+	args := llk.SepByN("args", types.Int(), types.Text(','), 1, 3)
+
+	r := args.Parse(llk.NewTokeniser(strings.NewReader("1, 2")))
+	if v := fmt.Sprint(r.Value()); v != "[1 2]" {
+		t.Errorf("expected [1 2], got %v", r)
+	}
+	r = args.Parse(llk.NewTokeniser(strings.NewReader("1, 2, 3, 4")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "1:1: expected 1 to 3 elements, got 4" {
+		t.Errorf("expected too many elements, got %v", errs)
+	}
+}
+
+func TestFramed(t *testing.T) {
+	row := llk.Framed("row", types.Text('|'), types.Ident(), types.Text('|'))
+	for _, input := range []string{"| a | b |", "a | b"} {
+		r := row.Parse(llk.NewTokeniser(strings.NewReader(input)))
+		if _, ok := r.Locs()[len(strings.Fields(input))]; !ok || fmt.Sprint(r.Value()) != "[a b]" {
+			t.Errorf("%s: expected [a b], got %v", input, r)
+		}
+	}
+}
+
+func TestOrderedMapOf(t *testing.T) {
+	p := llk.OrderedMapOf("map", types.Ident(), types.Int(), types.Text('='), types.Text(','))
+
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("b=1,a=2")))
+	m, ok := r.Value().(llk.OrderedMap)
+	if !ok {
+		t.Fatalf("expected a map, got %v", r)
+	}
+	if keys := fmt.Sprint(m.Keys()); keys != "[b a]" {
+		t.Errorf("expected b before a, got %v", keys)
+	}
+	if v, ok := m.Get("a"); !ok || v != int64(2) {
+		t.Errorf("expected a=2, got %v", v)
+	}
+}
+
+func TestExactly(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("1 2 3"))
+	if r := llk.Exactly(3, llk.SeqInt("").Int().Int()).Parse(tokeniser); len(r.Errors()) != 0 {
+		t.Errorf("expected success, got %v", r)
+	}
+
+	tokeniser = llk.NewTokeniser(strings.NewReader("1 2 3"))
+	r := llk.Exactly(3, llk.SeqInt("").Int()).Parse(tokeniser)
+	if len(r.Errors()) != 1 || r.Errors()[0].Error() != "expected 3 tokens" {
+		t.Errorf("expected failure, got %v", r)
+	}
+}
+
+func TestCount(t *testing.T) {
+	rgb := llk.Count("rgb", 3, types.Int())
+
+	r := rgb.Parse(llk.NewTokeniser(strings.NewReader("255 128 0 1")))
+	if _, ok := r.Locs()[3]; !ok || fmt.Sprint(r.Value()) != "[255 128 0]" {
+		t.Errorf("expected exactly three integers, got %v", r)
+	}
+	r = rgb.Parse(llk.NewTokeniser(strings.NewReader("255 128")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "expected 3 occurrences of integer, found 2" {
+		t.Errorf("expected too few integers, got %v", errs)
+	}
+
+	r = llk.Count("none", 0, types.Int()).Parse(llk.NewTokeniser(strings.NewReader("x")))
+	if vs, ok := r.Value().([]any); !ok || len(vs) != 0 {
+		t.Errorf("expected an empty slice, got %v", r)
+	}
+
+	r = llk.Count("", -1, types.Int()).Parse(llk.NewTokeniser(strings.NewReader("1")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "1:1: negative count -1 of integer" {
+		t.Errorf("expected a negative count to fail, got %v", r)
+	}
+}
+
+func TestUntil(t *testing.T) {
+	p := llk.Until("block", types.Ident(), types.Id("end"))
+
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("a b c end")))
+	vs, ok := r.Value().([]any)
+	if !ok || len(vs) != 3 || vs[0] != "a" || vs[1] != "b" || vs[2] != "c" {
+		t.Errorf("expected [a b c], got %v", r.Value())
+	}
+	if _, ok := r.Locs()[4]; !ok {
+		t.Errorf("expected the terminator to be consumed, got %v", r.Locs())
+	}
+
+	r = p.Parse(llk.NewTokeniser(strings.NewReader("a b c")))
+	if len(r.Errors()) == 0 || r.Errors()[0].Error() != "expected end" {
+		t.Errorf("expected a missing terminator error, got %v", r)
+	}
+}
+
+func TestManyLazy(t *testing.T) {
+	// any token, the terminator included
+	token := llk.Either("", types.Ident()).Chain(types.Text(';'))
+	input := "a b ; c ;"
+
+	r := llk.Many("", token).Parse(llk.NewTokeniser(strings.NewReader(input)))
+	if v := fmt.Sprint(r.Value()); v != "[a b ; c ;]" {
+		t.Errorf("expected Many to consume everything, got %v", v)
+	}
+
+	p := llk.ManyLazy("", token, types.Text(';')).Text(';')
+	r = p.Parse(llk.NewTokeniser(strings.NewReader(input)))
+	if _, ok := r.Locs()[3]; !ok || fmt.Sprint(r.Value()) != "[a b]" {
+		t.Errorf("expected ManyLazy to stop at the first ;, got %v", r)
+	}
+}
+
+func TestOpList(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("1 + 2 - 3"))
+
+	op := llk.EitherText("op", '+').Chain(types.Text('-'))
+	r := llk.OpList(types.Int(), op).Parse(tokeniser)
+	vs, ok := r.Value().([]any)
+	if !ok || len(vs) != 5 ||
+		vs[0] != int64(1) || vs[1] != "+" || vs[2] != int64(2) ||
+		vs[3] != "-" || vs[4] != int64(3) {
+		t.Errorf("expected [1 + 2 - 3], got %v", r.Value())
+	}
+}
+
+func TestPairs(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("a:1,a:2"))
+
+	p := llk.Pairs("pairs", types.Ident(), types.Int(), types.Text(':'), types.Text(','))
+	r := p.Parse(tokeniser)
+	ps, ok := r.Value().([][2]any)
+	if !ok || len(ps) != 2 ||
+		ps[0] != [2]any{"a", int64(1)} || ps[1] != [2]any{"a", int64(2)} {
+		t.Errorf("expected [[a 1] [a 2]], got %v", r.Value())
+	}
+}
+
+func TestChecked(t *testing.T) {
+	count := func(n, items any) bool {
+		return n.(int64) == int64(len(items.([]any)))
+	}
+	p := llk.Checked(types.Int(), llk.Many("", types.Ident()), count, "count mismatch")
+
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("3 a b c")))
+	if vs, ok := r.Value().([]any); !ok || len(vs) != 3 {
+		t.Errorf("expected [a b c], got %v", r)
+	}
+
+	r = p.Parse(llk.NewTokeniser(strings.NewReader("\n  4 a b c")))
+	if len(r.Errors()) == 0 || r.Errors()[0].Error() != "2:3: count mismatch" {
+		t.Errorf("expected a positioned count mismatch, got %v", r)
+	}
+}
+
+func TestColumns(t *testing.T) {
+	tokeniser := func(s string) types.Tokeniser {
+		return llk.NewTokeniser(strings.NewReader(s)).WithMode(0).WithWhitespace(0)
+	}
+	r := llk.Columns(3, 2, 3).Parse(tokeniser("abcdefgh"))
+	if v := fmt.Sprintf("%q", r.Value()); v != `["abc" "de" "fgh"]` {
+		t.Errorf(`expected ["abc" "de" "fgh"], got %v`, r)
+	}
+
+	rows := llk.SepBy("rows", llk.Columns(2, 1), types.Text('\n'))
+	r = rows.Parse(tokeniser("ab1\ncd2\nef3"))
+	if v := fmt.Sprintf("%q", r.Value()); v != `[["ab" "1"] ["cd" "2"] ["ef" "3"]]` {
+		t.Errorf("expected three rows, got %v", r)
+	}
+
+	if r := llk.Columns(3, 2).Parse(tokeniser("abc\nde")); len(r.Errors()) == 0 {
+		t.Errorf("expected a short row to fail, got %v", r)
+	}
+}
+
+func TestRange(t *testing.T) {
+	tokeniser := func(s string) types.Tokeniser {
+		// without floats 1..5 is not scanned as 1. and .5
+		return llk.NewTokeniser(strings.NewReader(s)).WithMode(scanner.ScanInts)
+	}
+	dots := llk.Seq("", types.Text('.')).Text('.')
+	ordered := func(lo, hi any) bool {
+		return lo.(int64) <= hi.(int64)
+	}
+
+	r := llk.Range(types.Int(), dots, ordered).Parse(tokeniser("1..5"))
+	if r.Value() != [2]any{int64(1), int64(5)} {
+		t.Errorf("expected [1 5], got %v", r)
+	}
+
+	r = llk.Range(types.Int(), dots).Parse(tokeniser("5..1"))
+	if r.Value() != [2]any{int64(5), int64(1)} {
+		t.Errorf("expected [5 1] without a predicate, got %v", r)
+	}
+	r = llk.Range(types.Int(), dots, ordered).Parse(tokeniser("5..1"))
+	if len(r.Errors()) == 0 || r.Errors()[0].Error() != "1:1: low bound 5 exceeds high bound 1" {
+		t.Errorf("expected an out of order error, got %v", r)
+	}
+}
@@ -0,0 +1,116 @@
+package examples
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"llk"
+	"llk/types"
+)
+
+func TestWithValue(t *testing.T) {
+	r := llk.SeqInt("").Int().Parse(llk.NewTokeniser(strings.NewReader("1 2")))
+
+	s := r.WithValue("two")
+	if s.Value() != "two" {
+		t.Errorf("expected the value two, got %v", s.Value())
+	}
+	if _, ok := s.Locs()[2]; !ok || len(s.Locs()) != 1 {
+		t.Errorf("expected the locations to be preserved, got %v", s.Locs())
+	}
+	if r.Value() != int64(1) {
+		t.Errorf("expected the original result to be unchanged, got %v", r.Value())
+	}
+
+	f := types.NewFailed("integer")
+	if f.WithValue("two").Value() != nil {
+		t.Errorf("expected a failed result to have no value")
+	}
+}
+
+func TestMust(t *testing.T) {
+	r := llk.SeqInt("").Parse(llk.NewTokeniser(strings.NewReader("1")))
+	if v := llk.Must[int64](r); v != 1 {
+		t.Errorf("expected 1, got %v", v)
+	}
+
+	defer func() {
+		err, ok := recover().(error)
+		if !ok || err.Error() != "expected integer" {
+			t.Errorf("expected a panic with the parse error, got %v", err)
+		}
+	}()
+	r = llk.SeqInt("").Parse(llk.NewTokeniser(strings.NewReader("a")))
+	llk.Must[int64](r)
+	t.Errorf("expected Must to panic")
+}
+
+func TestTapErrors(t *testing.T) {
+	var (
+		values []any
+		errs   []types.ParseError
+	)
+	p := llk.EitherInt("value").
+		Chain(llk.SeqString("").
+			Tap(func(v any) {
+				values = append(values, v)
+			}).
+			TapErrors(func(e []types.ParseError) {
+				errs = append(errs, e...)
+			}))
+
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("1")))
+	if r.Value() != int64(1) {
+		t.Fatalf("expected 1, got %v", r)
+	}
+	if len(errs) != 1 || errs[0].Error() != "expected quoted string" || len(values) != 0 {
+		t.Errorf("expected the failing branch to be observed, got %v", errs)
+	}
+
+	errs = nil
+	p.Parse(llk.NewTokeniser(strings.NewReader(`"a"`)))
+	if len(errs) != 0 || len(values) != 1 || values[0] != "a" {
+		t.Errorf("expected the succeeding branch to be observed, got %v", values)
+	}
+}
+
+func TestKind(t *testing.T) {
+	r := llk.SeqInt("").Parse(llk.NewTokeniser(strings.NewReader("1")))
+	if k := r.Kind(); k != types.SucceededKind {
+		t.Errorf("expected succeeded, got %v", k)
+	}
+	r = llk.SeqInt("").Parse(llk.NewTokeniser(strings.NewReader("x")))
+	if k := r.Kind(); k != types.FailedKind {
+		t.Errorf("expected failed, got %v", k)
+	}
+	r = types.NewHalt("conversion", types.ErrInternal, types.Pos{})
+	if k := r.Kind(); k != types.HaltKind {
+		t.Errorf("expected halt, got %v", k)
+	}
+}
+
+// sumNode is a node of the abstract syntax tree of an addition
+type sumNode struct {
+	a, b any
+}
+
+func TestSetValueStringer(t *testing.T) {
+	types.SetValueStringer(func(v any) string {
+		if n, ok := v.(sumNode); ok {
+			return fmt.Sprintf("(+ %v %v)", n.a, n.b)
+		}
+		return fmt.Sprint(v)
+	})
+	defer types.SetValueStringer(nil)
+
+	p := llk.SeqInt("").Lazy(func(a any) llk.Parser {
+		return llk.SeqText("", '+').Chain(types.Int()).Return(func(b any) any {
+			return sumNode{a, b}
+		})
+	})
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("1 + 2")))
+	if s := fmt.Sprint(r); s != "succeeded with (+ 1 2) at 3" {
+		t.Errorf("expected the node formatted by the stringer, got %s", s)
+	}
+}
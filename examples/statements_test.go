@@ -0,0 +1,324 @@
+package examples
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"text/scanner"
+
+	"llk"
+	"llk/types"
+)
+
+func TestConditional(t *testing.T) {
+	// conditional parser for the grammar:
+	//
+	//	<cond> → `if` <ident> <body> { `elif` <ident> <body> } [ `else` <body> ]
+	//	<body> → `{` <int> `}`
+	body := llk.SeqText("body", '{').Chain(types.Int()).Text('}')
+	p := llk.Conditional(
+		types.Id("if"), types.Id("elif"), types.Id("else"), types.Ident(), body,
+	)
+
+	r := p.Parse(llk.NewTokeniser(strings.NewReader(
+		"if a { 1 } elif b { 2 } elif c { 3 } else { 4 }",
+	)))
+	expected := llk.IfElse{
+		Branches: []llk.Branch{
+			{Cond: "a", Body: int64(1)},
+			{Cond: "b", Body: int64(2)},
+			{Cond: "c", Body: int64(3)},
+		},
+		Else:    int64(4),
+		HasElse: true,
+	}
+	if !reflect.DeepEqual(r.Value(), expected) {
+		t.Errorf("expected %v, got %v", expected, r.Value())
+	}
+
+	r = p.Parse(llk.NewTokeniser(strings.NewReader("if a { 1 }")))
+	expected = llk.IfElse{Branches: []llk.Branch{{Cond: "a", Body: int64(1)}}}
+	if !reflect.DeepEqual(r.Value(), expected) {
+		t.Errorf("expected %v, got %v", expected, r.Value())
+	}
+}
+
+func TestBlock(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader(
+		"a\n  b\n  c\n    d\n  e\n",
+	)).WithIndentation()
+
+	var item llk.Chain
+	item = llk.Block(types.Ident(), types.Func(func(t types.Tokeniser) types.Result {
+		return item.Parse(t)
+	}))
+	r := item.Parse(tokeniser)
+	expected := llk.Tree{Header: "a", Children: []any{
+		llk.Tree{Header: "b"},
+		llk.Tree{Header: "c", Children: []any{
+			llk.Tree{Header: "d"},
+		}},
+		llk.Tree{Header: "e"},
+	}}
+	if !reflect.DeepEqual(r.Value(), expected) {
+		t.Errorf("expected %v, got %v", expected, r.Value())
+	}
+}
+
+func TestArgs(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader(
+		"echo \"hello world\" foo \nls",
+	)).WithMode(scanner.ScanStrings).WithWhitespace(0)
+
+	r := llk.Args().Parse(tokeniser)
+	want := []string{"echo", "hello world", "foo"}
+	if !reflect.DeepEqual(r.Value(), want) {
+		t.Fatalf("expected %q, got %v", want, r)
+	}
+	for loc := range r.Locs() {
+		tokeniser.Seek(loc)
+	}
+	if r := types.Text('\n').Parse(tokeniser); len(r.Errors()) > 0 {
+		t.Errorf("expected args to stop at the newline, got %v", r)
+	}
+}
+
+func TestAnchorAlias(t *testing.T) {
+	// a document of key value pairs where a value may be anchored, as
+	// in &name value, and referred to by a later alias, as in *name
+	var value llk.Chain
+	anchor := llk.Anchor(llk.Seq("", types.Text('&')).Chain(types.Ident()), types.Func(func(t types.Tokeniser) types.Result {
+		return value.Parse(t)
+	}))
+	alias := llk.Alias(llk.Seq("", types.Text('*')).Chain(types.Ident()))
+	value = llk.Either("value", types.Int()).Chain(anchor).Chain(alias)
+	pair := llk.Seq("", types.Ident()).Text(':').Chain(value)
+	doc := llk.Many("document", pair)
+
+	tokeniser := llk.NewTokeniser(strings.NewReader(
+		"x: &answer 42\ny: 7\nz: *answer",
+	)).WithEnv(llk.Anchors{})
+	if v := fmt.Sprint(doc.Parse(tokeniser).Value()); v != "[42 7 42]" {
+		t.Errorf("expected the alias to resolve to 42, got %v", v)
+	}
+
+	tokeniser = llk.NewTokeniser(strings.NewReader("z: *answer")).WithEnv(llk.Anchors{})
+	errs := pair.Parse(tokeniser).Errors()
+	if len(errs) == 0 || errs[len(errs)-1].Error() != "1:4: undefined anchor answer" {
+		t.Errorf("expected an undefined anchor error, got %v", errs)
+	}
+}
+
+func TestMaybe(t *testing.T) {
+	// a field declaration with an optional initial value
+	//
+	//	<field> → <ident> [ `=` <int> ]
+	field := llk.Seq("field", types.Ident()).
+		Chain(llk.Maybe("value", llk.Seq("", types.Text('=')).Chain(types.Int())))
+
+	r := field.Parse(llk.NewTokeniser(strings.NewReader("x = 0")))
+	if v := r.Value(); v != (llk.Option{Value: int64(0), Present: true}) {
+		t.Errorf("expected a present zero value, got %v", r)
+	}
+	r = field.Parse(llk.NewTokeniser(strings.NewReader("x")))
+	if v := r.Value(); v != (llk.Option{}) {
+		t.Errorf("expected an absent value, got %v", r)
+	}
+}
+
+func TestOptional(t *testing.T) {
+	// a port with a default
+	//
+	//	<addr> → <ident> [ `:` <int> ]
+	addr := llk.Seq("addr", types.Ident()).
+		Chain(llk.Optional("port", llk.SeqText("", ':').Chain(types.Int()), int64(80))).
+		Text(';')
+
+	if r := addr.Parse(llk.NewTokeniser(strings.NewReader("localhost:8080;"))); r.Value() != int64(8080) {
+		t.Errorf("expected 8080, got %v", r)
+	}
+	if r := addr.Parse(llk.NewTokeniser(strings.NewReader("localhost;"))); r.Value() != int64(80) {
+		t.Errorf("expected the default 80, got %v", r)
+	}
+
+	halt := types.Func(func(t types.Tokeniser) types.Result {
+		return types.NewHalt("conversion", types.ErrInternal, types.Pos{})
+	})
+	if r := llk.Optional("", halt, 0).Parse(llk.NewTokeniser(strings.NewReader("x"))); r.Kind() != types.HaltKind {
+		t.Errorf("expected the halt to propagate, got %v", r)
+	}
+}
+
+func TestUnparse(t *testing.T) {
+	// <expr> → <int> | `(` <expr> `+` <expr> `)`
+	var expr llk.Chain
+	sum := llk.CST("sum", llk.SeqText("", '(').
+		Lazy(func(any) llk.Parser {
+			return expr
+		}).
+		Lazy(func(a any) llk.Parser {
+			return llk.Seq("", types.Text('+')).
+				Chain(expr).
+				Text(')').
+				Return(func(b any) any {
+					return []any{a, b}
+				})
+		}))
+	expr = llk.Either("expr", llk.CST("int", types.Int())).Chain(sum)
+
+	input := "( 1 +  2 )"
+	tokeniser := llk.NewTokeniser(strings.NewReader(input)).WithSource()
+	n, ok := expr.Parse(tokeniser).Value().(llk.Node)
+	if !ok {
+		t.Fatalf("expected a node")
+	}
+	if s := llk.Unparse(n); s != input {
+		t.Errorf("expected %q, got %q", input, s)
+	}
+	if len(n.Children) != 5 {
+		t.Fatalf("expected ( int + int ), got %v", n.Children)
+	}
+	if s := llk.Unparse(n.Children[3].(llk.Node)); s != "  2" {
+		t.Errorf("expected the second operand to keep its spaces, got %q", s)
+	}
+}
+
+func TestWarn(t *testing.T) {
+	// assignments, warning about names which shadow a builtin
+	//
+	//	<assign> → <ident> `=` <int>
+	name := llk.Warn(types.Ident(), func(v any) *llk.Diagnostic {
+		if v == "len" {
+			return &llk.Diagnostic{Message: "len shadows a builtin"}
+		}
+		return nil
+	})
+	assign := llk.Seq("assign", name).Text('=').Int()
+	program := llk.Lint(llk.Many("program", assign))
+
+	tokeniser := llk.NewTokeniser(strings.NewReader("x = 1\nlen = 2")).WithEnv(&llk.Diagnostics{})
+	r := program.Parse(tokeniser)
+	linted, ok := r.Value().(llk.Linted)
+	if !ok {
+		t.Fatalf("expected the program to parse, got %v", r)
+	}
+	if v := fmt.Sprint(linted.Value); v != "[x len]" {
+		t.Errorf("expected [x len], got %v", v)
+	}
+	if ds := linted.Diagnostics; len(ds) != 1 || ds[0].Pos.String() != "2:1" || ds[0].Message != "len shadows a builtin" {
+		t.Errorf("expected one warning at 2:1, got %v", ds)
+	}
+}
+
+func TestElement(t *testing.T) {
+	// <element> → `<` <ident> `>` { <ident> } `<` `/` <ident> `>`
+	openTag := llk.SeqText("", '<').Chain(types.Ident()).Text('>')
+	closeTag := llk.SeqText("", '<').Text('/').Chain(types.Ident()).Text('>')
+	element := llk.Element(openTag, closeTag, types.Ident())
+
+	r := element.Parse(llk.NewTokeniser(strings.NewReader("<a></a>")))
+	if tree, ok := r.Value().(llk.Tree); !ok || tree.Header != "a" || len(tree.Children) != 0 {
+		t.Errorf("expected an empty element a, got %v", r)
+	}
+	r = element.Parse(llk.NewTokeniser(strings.NewReader("<a>x y</a>")))
+	if tree, ok := r.Value().(llk.Tree); !ok || fmt.Sprint(tree.Children) != "[x y]" {
+		t.Errorf("expected the children x and y, got %v", r)
+	}
+
+	r = element.Parse(llk.NewTokeniser(strings.NewReader("<a></b>")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "1:4: closing tag b does not match tag a opened at 1:1" {
+		t.Errorf("expected mismatched tags, got %v", errs)
+	}
+}
+
+func TestUnique(t *testing.T) {
+	// struct fields, one per line, whose names have to be unique
+	//
+	//	<field> → <ident> <ident>
+	field := llk.Seq("field", types.Ident()).Lazy(func(name any) llk.Parser {
+		return llk.Seq("", types.Ident()).Return(func(typ any) any {
+			return [2]any{name, typ}
+		})
+	})
+	fields := llk.Unique("fields", llk.Many("", field), func(v any) any {
+		return v.([2]any)[0]
+	}, "duplicate field")
+
+	r := fields.Parse(llk.NewTokeniser(strings.NewReader("x int\ny string")))
+	if v := fmt.Sprint(r.Value()); v != "[[x int] [y string]]" {
+		t.Errorf("expected two fields, got %v", r)
+	}
+
+	r = fields.Parse(llk.NewTokeniser(strings.NewReader("x int\ny string\nx bool")))
+	errs := r.Errors()
+	if len(errs) != 1 || errs[0].Error() != "3:1: duplicate field" {
+		t.Errorf("expected the second x to be a duplicate, got %v", errs)
+	}
+}
+
+func TestUniqueUntil(t *testing.T) {
+	names := llk.Unique("names", llk.Until("", types.Ident(), types.Id("end")), func(v any) any {
+		return v
+	}, "duplicate name")
+
+	if r := names.Parse(llk.NewTokeniser(strings.NewReader("a b end"))); fmt.Sprint(r.Value()) != "[a b]" {
+		t.Errorf("expected two names, got %v", r)
+	}
+	r := names.Parse(llk.NewTokeniser(strings.NewReader("a b a end")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "1:5: duplicate name" {
+		t.Errorf("expected the second a to be a duplicate, got %v", errs)
+	}
+}
+
+func TestUniquePairs(t *testing.T) {
+	pairs := llk.Unique("pairs", llk.Pairs("", types.Ident(), types.Int(), types.Text('='), types.Text(',')), func(v any) any {
+		return v.([2]any)[0]
+	}, "duplicate key")
+
+	r := pairs.Parse(llk.NewTokeniser(strings.NewReader("a = 1, b = 2")))
+	if ps, ok := r.Value().([][2]any); !ok || len(ps) != 2 {
+		t.Errorf("expected the pairs unchanged, got %v", r)
+	}
+	r = pairs.Parse(llk.NewTokeniser(strings.NewReader("a = 1, a = 2")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "1:8: duplicate key" {
+		t.Errorf("expected the second a to be a duplicate, got %v", errs)
+	}
+}
+
+func TestErrorProduction(t *testing.T) {
+	// a comparison, accepting = written in place of == as a mistake
+	//
+	//	<cond> → <ident> `=` `=` <int> | <ident> `=` <int>
+	cond := llk.Either("cond", llk.Seq("", types.Ident()).Text('=').Text('=').Int()).
+		Chain(llk.ErrorProduction(llk.Seq("", types.Ident()).Text('=').Int(), "use == to compare, = assigns"))
+	stmt := llk.SeqId("if", "if").Chain(cond).Text('{').Text('}')
+
+	diagnostics := &llk.Diagnostics{}
+	r := stmt.Parse(llk.NewTokeniser(strings.NewReader("if x = 1 {}")).WithEnv(diagnostics))
+	if len(r.Errors()) != 0 {
+		t.Fatalf("expected the mistake to parse, got %v", r)
+	}
+	if ds := *diagnostics; len(ds) != 1 || ds[0].Pos.String() != "1:4" || ds[0].Message != "use == to compare, = assigns" {
+		t.Errorf("expected the mistake to be diagnosed, got %v", ds)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	assign := llk.Sequence("assign", types.Ident(), types.Text('='), types.Int()).Return(func(v any) any {
+		vs := v.([]any)
+		return fmt.Sprintf("%s:%d", vs[0], vs[2])
+	})
+	if r := assign.Parse(llk.NewTokeniser(strings.NewReader("x = 1"))); r.Value() != "x:1" {
+		t.Errorf("expected x:1, got %v", r)
+	}
+	r := assign.Parse(llk.NewTokeniser(strings.NewReader("x 1")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "expected text" {
+		t.Errorf("expected the missing = to fail, got %v", errs)
+	}
+
+	r = llk.Sequence("").Parse(llk.NewTokeniser(strings.NewReader("x")))
+	if vs, ok := r.Value().([]any); !ok || len(vs) != 0 || len(r.Locs()) != 1 {
+		t.Errorf("expected an empty sequence to succeed at once, got %v", r)
+	}
+}
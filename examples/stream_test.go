@@ -0,0 +1,95 @@
+package examples
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"llk"
+	"llk/types"
+)
+
+func TestParseStream(t *testing.T) {
+	var input []string
+	for i := 0; i < 1000; i++ {
+		input = append(input, fmt.Sprint(i))
+	}
+	p := llk.SepBy("list", types.Int(), types.Text(','))
+
+	var emitted []any
+	r := p.ParseStream(
+		llk.NewTokeniser(strings.NewReader(strings.Join(input, ","))),
+		func(v any) error {
+			emitted = append(emitted, v)
+			return nil
+		},
+	)
+	if len(emitted) != 1000 || emitted[0] != int64(0) || emitted[999] != int64(999) {
+		t.Errorf("expected 1000 elements to be emitted, got %d", len(emitted))
+	}
+	if vs, ok := r.Value().([]any); !ok || len(vs) != 0 {
+		t.Errorf("expected the elements not to be collected, got %v", r.Value())
+	}
+
+	errStop := errors.New("stop")
+	emitted = nil
+	r = p.ParseStream(
+		llk.NewTokeniser(strings.NewReader(strings.Join(input, ","))),
+		func(v any) error {
+			emitted = append(emitted, v)
+			if len(emitted) == 10 {
+				return errStop
+			}
+			return nil
+		},
+	)
+	h, ok := r.(types.Halt)
+	if !ok || h.Component() != "emit" || !errors.Is(h.Err(), errStop) {
+		t.Fatalf("expected an emit halt, got %v", r)
+	}
+	if len(emitted) != 10 {
+		t.Errorf("expected parsing to stop after 10 elements, got %d", len(emitted))
+	}
+}
+
+func TestParseStreamWrapped(t *testing.T) {
+	// a list read through a window and through trivia is still the
+	// outermost list of the stream
+	list := llk.SepBy("list", types.Int(), types.Text(','))
+	for name, tc := range map[string]struct {
+		p     llk.Chain
+		input string
+	}{
+		"Within":     {p: llk.Within(5, list), input: "1, 2, 3, 4"},
+		"WithTrivia": {p: llk.WithTrivia(types.Comment(), list), input: "1, /*c*/ 2, 3"},
+	} {
+		var emitted []any
+		tokeniser := llk.NewTokeniser(strings.NewReader(tc.input)).WithComments()
+		r := tc.p.ParseStream(tokeniser, func(v any) error {
+			emitted = append(emitted, v)
+			return nil
+		})
+		if fmt.Sprint(emitted) != "[1 2 3]" || len(r.Value().([]any)) != 0 {
+			t.Errorf("%s: expected the elements to be emitted, got %v and %v", name, emitted, r)
+		}
+	}
+}
+
+func TestParseConcurrent(t *testing.T) {
+	segments := make([]io.Reader, 100)
+	for i := range segments {
+		segments[i] = strings.NewReader(fmt.Sprintf("%d + %d", i, i))
+	}
+
+	results, err := llk.ParseConcurrent(segments, llk.Expression(sum{}, "+-", "*/"), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if v := r.Value(); v != int64(2*i) {
+			t.Errorf("segment %d: expected %d, got %v", i, 2*i, r)
+		}
+	}
+}
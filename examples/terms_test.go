@@ -0,0 +1,481 @@
+package examples
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"text/scanner"
+	"unicode"
+
+	"llk"
+	"llk/types"
+)
+
+func TestConverterPanic(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("1 + 42"))
+
+	var m map[string]any
+	p := llk.SeqInt("").Text('+').Chain(types.Int().
+		WithConverter(func(s string) (any, error) {
+			m[s] = s
+			return m, nil
+		}))
+
+	h, ok := p.Parse(tokeniser).(types.Halt)
+	if !ok {
+		t.Fatalf("expected a halt")
+	}
+	if h.Component() != "conversion" {
+		t.Errorf("expected a conversion halt, got %q", h.Component())
+	}
+	if h.Pos().Line != 1 || h.Pos().Column != 5 {
+		t.Errorf("expected a halt at 1:5, got %v", h.Pos())
+	}
+	if !strings.Contains(h.Error(), "1:5: conversion") {
+		t.Errorf("unexpected halt message %q", h.Error())
+	}
+}
+
+// keywords returns n distinct keywords
+func keywords(n int) []string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = fmt.Sprintf("kw%d", i)
+	}
+	return words
+}
+
+func TestKeywords(t *testing.T) {
+	p := types.Keywords(keywords(50)...)
+
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("kw42")))
+	if r.Value() != "kw42" {
+		t.Errorf("expected kw42, got %v", r.Value())
+	}
+
+	r = p.Parse(llk.NewTokeniser(strings.NewReader("kw50")))
+	if len(r.Errors()) != 1 {
+		t.Fatalf("expected failure, got %v", r)
+	}
+	msg := r.Errors()[0].Error()
+	if !strings.HasPrefix(msg, "expected one of kw0, kw1, kw10") ||
+		!strings.Contains(msg, "kw49") {
+		t.Errorf("unexpected error message %q", msg)
+	}
+}
+
+func TestNot(t *testing.T) {
+	keyword := llk.EitherId("", "if").Chain(types.Id("else"))
+	name := llk.Seq("name", llk.Not("keyword", keyword)).Chain(types.Ident())
+
+	if r := name.Parse(llk.NewTokeniser(strings.NewReader("iffy"))); r.Value() != "iffy" {
+		t.Errorf("expected iffy, got %v", r)
+	}
+	r := name.Parse(llk.NewTokeniser(strings.NewReader("\n else")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "2:2: unexpected keyword" {
+		t.Errorf("expected a keyword not to be a name, got %v", errs)
+	}
+
+	halt := types.Func(func(t types.Tokeniser) types.Result {
+		t.Inc()
+		return types.NewHalt("test", fmt.Errorf("stop"), types.Pos{})
+	})
+	tokeniser := llk.NewTokeniser(strings.NewReader("x"))
+	if r := llk.Not("", halt).Parse(tokeniser); r.Kind() != types.HaltKind || tokeniser.Loc() != 0 {
+		t.Errorf("expected the halt to propagate without consuming input, got %v at %d", r, tokeniser.Loc())
+	}
+}
+
+func BenchmarkKeywords(b *testing.B) {
+	p := types.Keywords(keywords(50)...)
+	for i := 0; i < b.N; i++ {
+		p.Parse(llk.NewTokeniser(strings.NewReader("kw49")))
+	}
+}
+
+func BenchmarkKeywordsEither(b *testing.B) {
+	words := keywords(50)
+	p := llk.EitherId("keyword", words[0])
+	for _, w := range words[1:] {
+		p = p.Chain(types.Id(w))
+	}
+	for i := 0; i < b.N; i++ {
+		p.Parse(llk.NewTokeniser(strings.NewReader("kw49")))
+	}
+}
+
+func TestPath(t *testing.T) {
+	r := llk.Path().Parse(llk.NewTokeniser(strings.NewReader("a.b[0].c")))
+	vs, ok := r.Value().([]any)
+	if !ok || len(vs) != 4 ||
+		vs[0] != "a" || vs[1] != "b" || vs[2] != int64(0) || vs[3] != "c" {
+		t.Errorf("expected [a b 0 c], got %v", r.Value())
+	}
+
+	for _, s := range []string{"a[0", "a[b]", "a.b[0.c", "a."} {
+		r := llk.Path().Parse(llk.NewTokeniser(strings.NewReader(s)))
+		if len(r.Errors()) == 0 {
+			t.Errorf("expected %q to fail, got %v", s, r)
+		}
+	}
+}
+
+func TestDigitSeparators(t *testing.T) {
+	r := types.Int().Parse(llk.NewTokeniser(strings.NewReader("1_000")))
+	if r.Value() != int64(1000) {
+		t.Errorf("expected 1000, got %v", r.Value())
+	}
+
+	r = types.Float().Parse(llk.NewTokeniser(strings.NewReader("1_000.5")))
+	if r.Value() != 1000.5 {
+		t.Errorf("expected 1000.5, got %v", r.Value())
+	}
+}
+
+func TestIdFold(t *testing.T) {
+	for _, s := range []string{"SELECT", "Select", "select"} {
+		r := types.IdFold("select").Parse(llk.NewTokeniser(strings.NewReader(s)))
+		if r.Value() != "select" {
+			t.Errorf("expected %q to match select, got %v", s, r)
+		}
+	}
+
+	r := types.IdFold("select").Parse(llk.NewTokeniser(strings.NewReader("selects")))
+	if len(r.Errors()) == 0 {
+		t.Errorf("expected failure, got %v", r)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("a  = 1"))
+
+	r := llk.SeqId("", "a").Chain(types.Position()).Text('=').Parse(tokeniser)
+	pos, ok := r.Value().(types.Pos)
+	if !ok || pos.Line != 1 || pos.Column != 4 || pos.Loc != 1 {
+		t.Errorf("expected position 1:4 at location 1, got %v", r.Value())
+	}
+}
+
+func TestRadix(t *testing.T) {
+	for s, v := range map[string]int64{
+		"0xFF":  255,
+		"0b101": 5,
+		"0o17":  15,
+		"017":   15,
+		"42":    42,
+	} {
+		r := types.Radix().Parse(llk.NewTokeniser(strings.NewReader(s)))
+		if r.Value() != v {
+			t.Errorf("expected %q to parse as %d, got %v", s, v, r)
+		}
+	}
+}
+
+func TestJSONPointer(t *testing.T) {
+	chars := func(s string) types.Tokeniser {
+		return llk.NewTokeniser(strings.NewReader(s)).WithMode(0).WithWhitespace(0)
+	}
+
+	r := types.JSONPointer().Parse(chars("/a/b~1c/~0 d"))
+	refs, ok := r.Value().([]string)
+	if !ok || len(refs) != 3 || refs[0] != "a" || refs[1] != "b/c" || refs[2] != "~ d" {
+		t.Errorf("expected [a b/c ~ d], got %v", r.Value())
+	}
+
+	for _, s := range []string{"a/b", "/a~2"} {
+		if r := types.JSONPointer().Parse(chars(s)); len(r.Errors()) == 0 {
+			t.Errorf("expected %q to fail, got %v", s, r)
+		}
+	}
+}
+
+func TestWithLeadingComment(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader(
+		"/* license */\n// doc\nfunc f",
+	)).WithTrivia()
+
+	r := llk.Seq("", llk.WithLeadingComment(types.Id("func"))).
+		Passthrough(types.Ident()).
+		Parse(tokeniser)
+	v, ok := r.Value().(llk.Commented)
+	if !ok || v.Comment != "// doc" || v.Value != "func" {
+		t.Errorf("expected func with the comment // doc, got %v", r.Value())
+	}
+}
+
+func TestLocaleNumber(t *testing.T) {
+	chars := func(s string) types.Tokeniser {
+		return llk.NewTokeniser(strings.NewReader(s)).WithMode(0).WithWhitespace(0)
+	}
+
+	r := types.LocaleNumber(',', '.').Parse(chars("1,234.56"))
+	if r.Value() != 1234.56 {
+		t.Errorf("expected 1234.56, got %v", r)
+	}
+
+	r = types.LocaleNumber('.', ',').Parse(chars("1.234,5"))
+	if r.Value() != 1234.5 {
+		t.Errorf("expected 1234.5, got %v", r)
+	}
+
+	r = types.LocaleNumber(',', '.').Parse(chars("1,000, 2"))
+	if _, ok := r.Locs()[5]; !ok || r.Value() != 1000.0 {
+		t.Errorf("expected 1000 finishing before the separator, got %v", r)
+	}
+}
+
+func TestBalancedAware(t *testing.T) {
+	r := types.BalancedAware('(', ')').
+		Parse(llk.NewTokeniser(strings.NewReader(`( ")" (1) ) 2`)))
+	if region, ok := r.Value().([]types.Token); !ok || len(region) != 4 {
+		t.Errorf("expected a region of 4 tokens, got %v", r)
+	}
+
+	chars := llk.NewTokeniser(strings.NewReader(`( ")\"" ')' ) 2`)).
+		WithMode(0).
+		WithWhitespace(0)
+	r = types.BalancedAware('(', ')').Parse(chars)
+	if region, ok := r.Value().([]types.Token); !ok || len(region) != 11 {
+		t.Errorf("expected a region of 11 characters, got %v", r)
+	}
+	if _, ok := r.Locs()[13]; !ok {
+		t.Errorf("expected the region to finish at location 13, got %v", r.Locs())
+	}
+
+	r = types.BalancedAware('(', ')').
+		Parse(llk.NewTokeniser(strings.NewReader(`( (1) `)))
+	if len(r.Errors()) == 0 {
+		t.Errorf("expected an unbalanced region to fail, got %v", r)
+	}
+}
+
+func TestWithExactMatchAny(t *testing.T) {
+	p := types.Ident().WithExactMatchAny("and", "or")
+
+	for _, s := range []string{"and", "or"} {
+		if r := p.Parse(llk.NewTokeniser(strings.NewReader(s))); r.Value() != s {
+			t.Errorf("expected %q to match, got %v", s, r)
+		}
+	}
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("not")))
+	if len(r.Errors()) == 0 || r.Errors()[0].Error() != "expected one of and, or" {
+		t.Errorf("expected failure, got %v", r)
+	}
+}
+
+func TestHeredoc(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader(
+		"<<EOF\nline one\n  EOF two\nEOF\nrest",
+	)).WithMode(0).WithWhitespace(0)
+
+	marker := llk.SeqText("", '<').Text('<').Chain(types.Chars(unicode.IsLetter))
+	r := types.Heredoc(marker).Parse(tokeniser)
+	if r.Value() != "line one\n  EOF two" {
+		t.Errorf("expected the body between the markers, got %q", r.Value())
+	}
+
+	tokeniser = llk.NewTokeniser(strings.NewReader(
+		"<<EOF\nline one\n",
+	)).WithMode(0).WithWhitespace(0)
+	r = types.Heredoc(marker).Parse(tokeniser)
+	if len(r.Errors()) == 0 || r.Errors()[0].Error() != "expected EOF" {
+		t.Errorf("expected a missing marker error, got %v", r)
+	}
+}
+
+func TestAny2(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("x 42"))
+	tokens := llk.Seq("", types.Any2()).Lazy(func(a any) llk.Parser {
+		return llk.Seq("", types.Any2()).Return(func(b any) any {
+			return []types.Token{a.(types.Token), b.(types.Token)}
+		})
+	})
+	r := tokens.Parse(tokeniser)
+	vs, ok := r.Value().([]types.Token)
+	if !ok {
+		t.Fatalf("expected two tokens, got %v", r)
+	}
+	if vs[0].Category() != scanner.Ident || vs[0].Match() != "x" {
+		t.Errorf("expected the identifier x, got %v", vs[0])
+	}
+	if vs[1].Category() != scanner.Int || vs[1].Match() != "42" {
+		t.Errorf("expected the integer 42, got %v", vs[1])
+	}
+
+	if r := types.Any2().Parse(tokeniser); len(r.Errors()) == 0 {
+		t.Errorf("expected failure at the end of the input, got %v", r)
+	}
+}
+
+func TestNestedComment(t *testing.T) {
+	input := "/* a /* b */ c */"
+	tokeniser := llk.NewTokeniser(strings.NewReader(input)).WithMode(0).WithWhitespace(0)
+	r := types.NestedComment("/*", "*/").Parse(tokeniser)
+	if _, ok := r.Locs()[len(input)]; !ok || r.Value() != input {
+		t.Errorf("expected the whole nested comment, got %v", r)
+	}
+
+	tokeniser = llk.NewTokeniser(strings.NewReader("/* a /* b */")).WithMode(0).WithWhitespace(0)
+	r = types.NestedComment("/*", "*/").Parse(tokeniser)
+	if len(r.Errors()) == 0 || r.Errors()[0].Error() != "1:1: unterminated comment" {
+		t.Errorf("expected an unterminated comment error, got %v", r)
+	}
+}
+
+func TestMultilineString(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader(`"""first line
+"second" line\t"""`)).WithMode(0).WithWhitespace(0)
+	r := types.MultilineString(`"""`, `"""`).Parse(tokeniser)
+	if r.Value() != "first line\n\"second\" line\t" {
+		t.Errorf("expected both lines with the escape processed, got %q", r.Value())
+	}
+
+	tokeniser = llk.NewTokeniser(strings.NewReader(`"""open`)).WithMode(0).WithWhitespace(0)
+	r = types.MultilineString(`"""`, `"""`).Parse(tokeniser)
+	if len(r.Errors()) == 0 || r.Errors()[0].Error() != "1:1: unterminated string" {
+		t.Errorf("expected an unterminated string error, got %v", r)
+	}
+}
+
+func TestWithCategory(t *testing.T) {
+	p := types.Int().WithCategory(scanner.Float)
+
+	// the float token is matched and handed to the integer converter,
+	// which halts since 1.5 is not an integer
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("1.5")))
+	if h, ok := r.(types.Halt); !ok || h.Component() != "conversion" {
+		t.Errorf("expected a conversion halt, got %v", r)
+	}
+
+	r = p.Parse(llk.NewTokeniser(strings.NewReader("15")))
+	if len(r.Errors()) == 0 || r.Errors()[0].Error() != "expected integer" {
+		t.Errorf("expected an integer token to fail, got %v", r)
+	}
+}
+
+func TestCharClassLiteral(t *testing.T) {
+	class := func(s string) func(rune) bool {
+		tokeniser := llk.NewTokeniser(strings.NewReader(s)).
+			WithMode(0).
+			WithWhitespace(0)
+		pred, ok := llk.CharClassLiteral().Parse(tokeniser).Value().(func(rune) bool)
+		if !ok {
+			t.Fatalf("expected %s to parse", s)
+		}
+		return pred
+	}
+
+	if pred := class("[a-c]"); !pred('b') || pred('x') {
+		t.Errorf("expected [a-c] to match b but not x")
+	}
+	if pred := class(`[^\]0-9_]`); pred(']') || pred('5') || pred('_') || !pred('x') {
+		t.Errorf(`expected [^\]0-9_] to match x but not ], 5 or _`)
+	}
+}
+
+func TestDefer(t *testing.T) {
+	// a ratio is an integer followed by a colon, a serial is any run of
+	// digits followed by an identifier, so is not limited to an int64
+	ratio := func(n types.Term) llk.Chain {
+		return llk.Seq("ratio", n).Text(':').Return(func(v any) any {
+			return v
+		})
+	}
+	serial := llk.Seq("serial", types.Text(scanner.Int)).Passthrough(types.Ident())
+
+	input := "99999999999999999999 x"
+	p := llk.Either("", ratio(types.Int())).Chain(serial)
+	if _, ok := p.Parse(llk.NewTokeniser(strings.NewReader(input))).(types.Halt); !ok {
+		t.Errorf("expected the eager ratio to halt")
+	}
+
+	p = llk.Either("", ratio(types.Int().Defer())).Chain(serial)
+	r := p.Parse(llk.NewTokeniser(strings.NewReader(input)))
+	if r.Value() != "99999999999999999999" {
+		t.Errorf("expected the serial, got %v", r)
+	}
+
+	r = p.Parse(llk.NewTokeniser(strings.NewReader("42 :")))
+	if r.Value() != int64(42) {
+		t.Errorf("expected the ratio to be forced, got %v", r)
+	}
+	if v := llk.Must[string](types.Int().WithConverter(func(s string) (any, error) {
+		return "n" + s, nil
+	}).Defer().Parse(llk.NewTokeniser(strings.NewReader("7")))); v != "n7" {
+		t.Errorf("expected Must to force n7, got %v", v)
+	}
+}
+
+func TestIntRange(t *testing.T) {
+	p := types.IntRange(0, 100)
+	if r := p.Parse(llk.NewTokeniser(strings.NewReader("42"))); r.Value() != int64(42) {
+		t.Errorf("expected 42, got %v", r)
+	}
+
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("   200")))
+	if len(r.Errors()) != 1 {
+		t.Fatalf("expected an out of range error, got %v", r)
+	}
+	if err := r.Errors()[0]; err.Error() != "1:4: value 200 out of range [0,100]" || err.Pos().Column != 4 {
+		t.Errorf("expected 200 to be out of range at column 4, got %v", err)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	// <expr> → <int> | `(` <expr> `+` <expr> `)`
+	var expr llk.Chain
+	sub := llk.SeqText("subexpr", '(').
+		Lazy(func(any) llk.Parser {
+			return expr
+		}).
+		Text('+').
+		Lazy(func(any) llk.Parser {
+			return expr
+		}).
+		Text(')')
+	expr = llk.EitherInt("expr").Chain(sub)
+
+	r := llk.Highlight(expr).Parse(llk.NewTokeniser(strings.NewReader("(1 + 2)")))
+	h, ok := r.Value().(llk.Highlighted)
+	if !ok {
+		t.Fatalf("expected the spans, got %v", r)
+	}
+	var spans []string
+	for _, s := range h.Spans {
+		spans = append(spans, fmt.Sprintf("%s %s-%s", scanner.TokenString(s.Category), s.Start, s.End))
+	}
+	want := `"(" 1:1-1:2, Int 1:2-1:3, "+" 1:4-1:5, Int 1:6-1:7, ")" 1:7-1:8`
+	if got := strings.Join(spans, ", "); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	p := llk.Fingerprint(llk.Many("", types.Ident()))
+	sum := func(input string) uint64 {
+		r := p.Parse(llk.NewTokeniser(strings.NewReader(input)))
+		return r.Value().(llk.Fingerprinted).Sum
+	}
+
+	if a, b := sum("a b c"), sum("a  b\nc"); a != b {
+		t.Errorf("expected identical tokens to have the same fingerprint, got %x and %x", a, b)
+	}
+	if a, b := sum("a b c"), sum("a bc"); a == b {
+		t.Errorf("expected different tokens to have different fingerprints, got %x", a)
+	}
+}
+
+func TestDecoded(t *testing.T) {
+	p := llk.Decoded("path", types.String(), url.PathUnescape)
+
+	r := p.Parse(llk.NewTokeniser(strings.NewReader(`"hello%20world"`)))
+	if r.Value() != "hello world" {
+		t.Errorf("expected hello world, got %v", r)
+	}
+
+	r = p.Parse(llk.NewTokeniser(strings.NewReader(`  "bad%zz"`)))
+	if len(r.Errors()) == 0 || r.Errors()[0].Error() != `1:3: invalid URL escape "%zz"` {
+		t.Errorf("expected a positioned decode error, got %v", r)
+	}
+}
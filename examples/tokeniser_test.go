@@ -0,0 +1,269 @@
+package examples
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"text/scanner"
+
+	"llk"
+	"llk/types"
+)
+
+func TestTokeniserErr(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("a \xff b"))
+
+	p := types.Func(func(t types.Tokeniser) types.Result {
+		for _, ok := t.Peek(); ok && t.Err() == nil; _, ok = t.Peek() {
+			t.Inc()
+		}
+		if errors.Is(t.Err(), types.ErrBadCharset) {
+			return types.NewHalt("scanner", t.Err(), types.Pos{Loc: t.Loc()})
+		}
+		return types.NewSucceeded(nil, t.Loc())
+	})
+	h, ok := p.Parse(tokeniser).(types.Halt)
+	if !ok || !errors.Is(h.Err(), types.ErrBadCharset) {
+		t.Errorf("expected a bad charset halt, got %v", h)
+	}
+
+	// alternatives are not tried on input which could not be scanned
+	tokeniser = llk.NewTokeniser(strings.NewReader("a \xff"))
+	r := llk.Many("", llk.EitherInt("").Chain(types.Ident())).Chain(types.Ident()).Parse(tokeniser)
+	if h, ok := r.(types.Halt); !ok || h.Component() != "scan" || !errors.Is(h.Err(), types.ErrBadCharset) {
+		t.Errorf("expected a scan halt, got %v", r)
+	}
+	for _, ok := tokeniser.Peek(); ok; _, ok = tokeniser.Peek() {
+		tokeniser.Inc()
+	}
+	if !errors.Is(tokeniser.Err(), types.ErrBadCharset) {
+		t.Errorf("expected the scan error to outlast the end of the input, got %v", tokeniser.Err())
+	}
+
+	// End reports the scan error as the terms do
+	tokeniser = llk.NewTokeniser(strings.NewReader("a \xff"))
+	r = llk.Seq("", types.Ident()).Passthrough(llk.End()).Parse(tokeniser)
+	if h, ok := r.(types.Halt); !ok || h.Component() != "scan" || !errors.Is(h.Err(), types.ErrBadCharset) {
+		t.Errorf("expected End to halt with a scan error, got %v", r)
+	} else if errs := h.Errors(); len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "1:") {
+		t.Errorf("expected the scan error to be positioned, got %v", errs)
+	}
+
+	tokeniser = llk.NewTokeniser(strings.NewReader("a"))
+	types.Ident().Parse(tokeniser)
+	if tokeniser.Err() != nil {
+		t.Errorf("expected no error, got %v", tokeniser.Err())
+	}
+	tokeniser.Peek()
+	if !errors.Is(tokeniser.Err(), types.ErrEOF) {
+		t.Errorf("expected end of file, got %v", tokeniser.Err())
+	}
+}
+
+func TestRecordTokeniser(t *testing.T) {
+	recorder := llk.RecordTokeniser(llk.NewTokeniser(strings.NewReader("a = 1")))
+	llk.Seq("assign", types.Ident()).Text('=').Int().Parse(recorder)
+
+	want := "[{Peek 0} {Inc 1} " +
+		"{Seek 1} {Peek 1} {Inc 2} " +
+		"{Seek 2} {Seek 2} {Peek 2} {Inc 3} " +
+		"{Seek 3}]"
+	if trace := fmt.Sprint(recorder.Trace()); trace != want {
+		t.Errorf("expected trace %s, got %s", want, trace)
+	}
+
+	tokeniser := llk.NewTokeniser(strings.NewReader("a = 1"))
+	if err := llk.Replay(tokeniser, recorder.Trace()); err != nil {
+		t.Errorf("expected the trace to replay, got %v", err)
+	}
+}
+
+func TestWithWhitespace(t *testing.T) {
+	// the text between angle brackets keeps its spaces, outside of them
+	// spaces are skipped as usual
+	region := llk.Many("", llk.EitherText("", ' ').Chain(types.Ident()))
+	p := llk.Seq("", types.Ident()).
+		Text('<').
+		Lazy(func(any) llk.Parser {
+			return llk.Seq("", llk.WithWhitespace(true, region)).
+				Text('>').
+				Passthrough(types.Ident())
+		})
+
+	tokeniser := llk.NewTokeniser(strings.NewReader("say < a  b > end"))
+	r := p.Parse(tokeniser)
+	if fmt.Sprintf("%q", r.Value()) != `[" " "a" " " " " "b" " "]` {
+		t.Errorf("expected the spaces of the region, got %v", r)
+	}
+	if len(r.Locs()) != 1 {
+		t.Errorf("expected the parse to finish, got %v", r)
+	}
+}
+
+func TestComments(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader(
+		"x = 1 // the answer\ny = 2",
+	)).WithComments()
+
+	assign := llk.Seq("", types.Ident()).Text('=').Int()
+	p := llk.Seq("", assign).Chain(types.Comment()).Passthrough(assign)
+	if r := p.Parse(tokeniser); r.Value() != "// the answer" {
+		t.Errorf("expected the comment, got %v", r)
+	}
+}
+
+func TestNewTokeniserNormalized(t *testing.T) {
+	// compose is a stand in for a unicode normalization form, e.g.
+	// norm.NFC.String, which composes the one accent used here
+	compose := strings.NewReplacer("e\u0301", "\u00e9").Replace
+
+	p := types.Id("caf\u00e9")
+	for _, input := range []string{"caf\u00e9", "cafe\u0301"} {
+		tokeniser, err := llk.NewTokeniserNormalized(strings.NewReader(input), compose)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := p.Parse(tokeniser); len(r.Errors()) > 0 {
+			t.Errorf("expected %q to match, got %v", input, r)
+		}
+	}
+
+	// without normalization the combining accent is not part of the
+	// identifier
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("cafe\u0301")))
+	if len(r.Errors()) == 0 {
+		t.Errorf("expected the decomposed form not to match, got %v", r)
+	}
+}
+
+func TestChannelTokeniser(t *testing.T) {
+	// a lexer of its own sending the tokens of 1 + 2 + 3
+	ch := make(chan types.Token)
+	go func() {
+		defer close(ch)
+		for i, s := range []string{"1", "+", "2", "+", "3"} {
+			category := rune(scanner.Int)
+			if s == "+" {
+				category = '+'
+			}
+			ch <- types.NewToken(category, s).WithPos(types.Pos{Line: 1, Column: 2*i + 1})
+		}
+	}()
+
+	sum := llk.OpList(types.Int(), types.Text('+'))
+	r := sum.Parse(llk.NewChannelTokeniser(ch))
+	if v := fmt.Sprint(r.Value()); v != "[1 + 2 + 3]" {
+		t.Errorf("expected [1 + 2 + 3], got %v", r)
+	}
+}
+
+func TestAdjacent(t *testing.T) {
+	// a member access, a.b, as opposed to a . b
+	member := llk.Adjacent(llk.Adjacent(types.Ident(), types.Text('.')), types.Ident())
+
+	if r := member.Parse(llk.NewTokeniser(strings.NewReader("a.b"))); r.Value() != "b" {
+		t.Errorf("expected a.b to parse, got %v", r)
+	}
+	r := member.Parse(llk.NewTokeniser(strings.NewReader("a . b")))
+	if errs := r.Errors(); len(errs) != 1 || errs[0].Error() != "1:3: unexpected space before ." {
+		t.Errorf("expected a . b to fail, got %v", errs)
+	}
+}
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{"FOO": "8080"}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+	port := llk.SeqId("port", "port").Text('=').Chain(types.Int())
+
+	r, err := llk.ExpandVars(strings.NewReader("port = ${FOO}"), lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := port.Parse(llk.NewTokeniser(r)).Value(); v != int64(8080) {
+		t.Errorf("expected the expanded port 8080, got %v", v)
+	}
+
+	_, err = llk.ExpandVars(strings.NewReader("port = ${BAR}"), lookup)
+	if err == nil || err.Error() != "1:8: undefined variable BAR" {
+		t.Errorf("expected an undefined variable error, got %v", err)
+	}
+}
+
+func TestSource(t *testing.T) {
+	tokeniser := llk.NewTokeniser(strings.NewReader("x = 1\ny = ?\nz = 3"))
+	r := llk.Seq("config", types.Ident()).Text('=').Int().
+		Chain(types.Ident()).Text('=').Int().
+		Parse(tokeniser)
+
+	errs := r.Errors()
+	if len(errs) != 1 || errs[0].Error() != "expected integer" {
+		t.Fatalf("expected the second line to fail, got %v", r)
+	}
+	if line := tokeniser.Source(2); line != "y = ?" {
+		t.Errorf("expected the second line, got %q", line)
+	}
+	if line := tokeniser.Source(4); line != "" {
+		t.Errorf("expected no fourth line, got %q", line)
+	}
+}
+
+func TestBOM(t *testing.T) {
+	// the byte order mark and the blank lines before the first token
+	// are skipped, without shifting its column
+	tokeniser := llk.NewTokeniser(strings.NewReader("\uFEFF\n\nx = 1"))
+	r := types.Position().Parse(tokeniser)
+	if pos := r.Value(); pos != (types.Pos{Line: 3, Column: 1}) {
+		t.Errorf("expected x at 3:1, got %v", pos)
+	}
+	tokeniser = llk.NewTokeniser(strings.NewReader("\uFEFFx = 1"))
+	if r := llk.Seq("", types.Id("x")).Chain(types.Position()).Parse(tokeniser); r.Value() != (types.Pos{Line: 1, Column: 3, Loc: 1}) {
+		t.Errorf("expected = at 1:3, got %v", r)
+	}
+
+	// a significant byte order mark is a token of its own
+	tokeniser = llk.NewTokeniser(strings.NewReader("\uFEFFx")).WithBOM()
+	r = llk.SeqText("", '\uFEFF').Chain(types.Ident()).Parse(tokeniser)
+	if r.Value() != "x" {
+		t.Errorf("expected the byte order mark and then x, got %v", r)
+	}
+}
+
+func TestSubTokeniser(t *testing.T) {
+	// skim over the arguments of a call, then parse them in depth
+	tokeniser := llk.NewTokeniser(strings.NewReader("f(1 + 2 * 3) g"))
+	skim := llk.Seq("", types.Ident()).Chain(types.BalancedAware('(', ')'))
+	region := skim.Parse(tokeniser).Value().([]types.Token)
+	from, to := region[0].Pos().Loc, region[len(region)-1].Pos().Loc+1
+
+	sub := tokeniser.SubTokeniser(from, to)
+	r := llk.Expression(sum{}, "+-", "*/").Parse(sub)
+	if _, ok := r.Locs()[5]; !ok || r.Value() != int64(7) {
+		t.Errorf("expected the arguments to evaluate to 7, got %v", r)
+	}
+	if token, ok := sub.Peek(); ok {
+		t.Errorf("expected the region to end after the arguments, got %v", token)
+	}
+}
+
+func TestWithLexer(t *testing.T) {
+	// the body of a regular expression literal is scanned a character at
+	// a time, outside of it the input is scanned as usual
+	body := types.Chars(func(c rune) bool { return c != '/' })
+	p := llk.Seq("", types.Id("match")).
+		Text('/').
+		Chain(llk.WithLexer(body, llk.Lexer{})).
+		Lazy(func(re any) llk.Parser {
+			return llk.SeqText("", '/').Chain(types.Ident()).Return(func(name any) any {
+				return fmt.Sprintf("%s=%q", name, re)
+			})
+		})
+
+	r := p.Parse(llk.NewTokeniser(strings.NewReader("match /a+ b\"/ x")))
+	if r.Value() != `x="a+ b\""` {
+		t.Errorf("expected the literal to be scanned by its own rules, got %v", r)
+	}
+}
@@ -0,0 +1,45 @@
+package examples
+
+import (
+	"strings"
+	"testing"
+
+	"llk"
+	"llk/types"
+)
+
+func TestVersionedGrammar(t *testing.T) {
+	// declaration parser for the grammar, where `let` is only
+	// accepted by newer versions of the language:
+	//
+	//	<decl> → `var` <ident> | `let` <ident>
+	decl := func(newer types.Parser) llk.Chain {
+		keyword := llk.EitherId("keyword", "var").Chain(newer)
+		return llk.Seq("decl", keyword).Chain(types.Ident())
+	}
+	let := types.Id("let")
+	none := types.Func(func(types.Tokeniser) types.Result {
+		return types.NewFailed("var")
+	})
+
+	for _, newer := range []bool{false, true} {
+		p := decl(llk.If(newer, let, none))
+		r := p.Parse(llk.NewTokeniser(strings.NewReader("let x")))
+		if ok := len(r.Errors()) == 0; ok != newer {
+			t.Errorf("expected success %v for newer %v, got %v", newer, newer, r)
+		}
+	}
+
+	type env struct{ newer bool }
+	newer := func(e any) bool {
+		return e.(env).newer
+	}
+	p := decl(llk.IfEnv(newer, let, none))
+	for _, e := range []env{{false}, {true}} {
+		tokeniser := llk.NewTokeniser(strings.NewReader("let x")).WithEnv(e)
+		r := p.Parse(tokeniser)
+		if ok := len(r.Errors()) == 0; ok != e.newer {
+			t.Errorf("expected success %v for env %v, got %v", e.newer, e, r)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package llk
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"llk/types"
+)
+
+// RuleKind is the kind of a Rule of a Grammar
+type RuleKind string
+
+const (
+	// SeqRule matches its Rules one after another
+	SeqRule RuleKind = "seq"
+
+	// EitherRule matches any one of its Rules
+	EitherRule RuleKind = "either"
+
+	// TermRule matches a single token, see Rule
+	TermRule RuleKind = "term"
+
+	// RefRule matches the rule of the grammar named by
+	// Name
+	RefRule RuleKind = "ref"
+)
+
+// Rule is a node of the tree describing a rule of a Grammar. For a
+// TermRule, Name is the kind of token matched, one of "ident", "int",
+// "float", "string" or "text", and Match optionally the exact text of
+// the token, for "text" the single character matched. For a RefRule,
+// Name is the name of the rule referenced. SeqRule and EitherRule nodes
+// combine their Rules
+type Rule struct {
+	Kind  RuleKind
+	Name  string
+	Match string
+	Rules []Rule
+}
+
+// Grammar is a description of a grammar as plain data, its rules by
+// name and the name of the rule parsing starts from. A Grammar can be
+// built dynamically or serialised, and is turned into a parser by
+// Compile
+type Grammar struct {
+	Start string
+	Rules map[string]Rule
+}
+
+// Compile compiles the grammar g into a chainable parser of its start
+// rule. References between rules, including forward and recursive ones,
+// are resolved by name. The value of a sequence is the []any of the
+// values of its rules, of an alternative the value of the rule which
+// matched and of a term the value of its token. Compile returns an
+// error if g refers to a rule it does not define or describes a rule
+// which cannot be compiled
+func Compile(g Grammar) (Chain, error) {
+	rules := make(map[string]types.Parser, len(g.Rules))
+	c := compiler{g, rules}
+	for name, rule := range g.Rules {
+		p, err := c.compile(name, rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+		rules[name] = p
+	}
+	start, ok := rules[g.Start]
+	if !ok {
+		return nil, fmt.Errorf("undefined start rule %s", g.Start)
+	}
+	return Seq(g.Start, start), nil
+}
+
+// RuleSet holds the rules of a grammar by name, for its references to
+// look up. Unlike forward declaring each rule as a variable and closing
+// over it with Lazy, rules can be added to a RuleSet in any order and
+// refer to one another freely
+type RuleSet map[string]Chain
+
+// Ref returns a chainable parser which parses like the rule of rs named
+// name, looking it up at parse time, so the rule can be added to rs
+// after the reference is made, including by the rule itself. Parsing a
+// reference to a rule which rs does not hold halts, with the component
+// "rule"
+func (rs RuleSet) Ref(name string) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		p, ok := rs[name]
+		if !ok {
+			return types.NewHalt("rule", fmt.Errorf("undefined rule %s", name), peekPos(t))
+		}
+		return p.Parse(t)
+	}))
+}
+
+// compiler compiles the rules of a grammar, rules holds the compiled
+// rules by name, through which references are resolved at parse time
+type compiler struct {
+	grammar Grammar
+	rules   map[string]types.Parser
+}
+
+// compile compiles the rule r of the rule named name
+func (c compiler) compile(name string, r Rule) (types.Parser, error) {
+	switch r.Kind {
+	case TermRule:
+		return term(r)
+	case RefRule:
+		if _, ok := c.grammar.Rules[r.Name]; !ok {
+			return nil, fmt.Errorf("undefined rule %s", r.Name)
+		}
+		return types.Func(func(t types.Tokeniser) types.Result {
+			return c.rules[r.Name].Parse(t)
+		}), nil
+	}
+
+	if len(r.Rules) == 0 {
+		return nil, fmt.Errorf("empty %s", r.Kind)
+	}
+	ps := make([]types.Parser, len(r.Rules))
+	for i, rule := range r.Rules {
+		p, err := c.compile(name, rule)
+		if err != nil {
+			return nil, err
+		}
+		ps[i] = p
+	}
+	switch r.Kind {
+	case SeqRule:
+		return sequence(name, ps, nil), nil
+	case EitherRule:
+		p := Either(name, ps[0])
+		for _, q := range ps[1:] {
+			p = p.Chain(q)
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown kind of rule %q", r.Kind)
+}
+
+// term returns the Term described by the TermRule r
+func term(r Rule) (types.Parser, error) {
+	var t types.Term
+	switch r.Name {
+	case "ident":
+		t = types.Ident()
+	case "int":
+		t = types.Int()
+	case "float":
+		t = types.Float()
+	case "string":
+		t = types.String()
+	case "text":
+		c, n := utf8.DecodeRuneInString(r.Match)
+		if n == 0 || n != len(r.Match) {
+			return nil, fmt.Errorf("text %q is not a single character", r.Match)
+		}
+		return types.Text(c), nil
+	default:
+		return nil, fmt.Errorf("unknown term %q", r.Name)
+	}
+	if r.Match != "" {
+		t = t.WithExactMatch(r.Match)
+	}
+	return t, nil
+}
+
+// sequence returns a parser which applies each of ps in turn, appending
+// their values to vs
+func sequence(name string, ps []types.Parser, vs []any) types.Parser {
+	if len(ps) == 0 {
+		return types.NewEmpty(vs)
+	}
+	return Seq(name, ps[0]).Lazy(func(v any) Parser {
+		return sequence(name, ps[1:], append(vs[:len(vs):len(vs)], v))
+	})
+}
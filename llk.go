@@ -6,8 +6,14 @@
 package llk
 
 import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
 	"strings"
 	"text/scanner"
+	"unicode"
 
 	"llk/types"
 )
@@ -24,75 +30,6 @@ type Parser = types.Parser
 // determined by a "folder"
 type Chain = *types.M
 
-// tokeniser implements of the Tokeniser interface, emmiting tokens from
-// the scanner and storing scanned tokens in tokens
-type tokeniser struct {
-	scanner *scanner.Scanner
-
-	// tokens is the sequence of tokens already scanned
-	// by the tokeniser, the kth token to be scanned is
-	// stored at the kth index of tokens
-	tokens []types.Token
-
-	// loc is the "current" location of the tokeniser,
-	// returned by Loc()
-	loc int
-}
-
-func NewTokeniser(r *strings.Reader) *tokeniser {
-	s := &scanner.Scanner{}
-	s.Init(r)
-
-	return &tokeniser{
-		scanner: s,
-	}
-}
-
-// Loc returns the current location of the Tokeniser
-func (t tokeniser) Loc() int {
-	return t.loc
-}
-
-// Dec moves the Tokensier to the previous location in the token stream,
-// calling Dec to move before the "begning" of the token stream is an
-// error and results in a panic
-func (t *tokeniser) Dec() {
-	t.loc--
-}
-
-// Inc moves the Tokensier to the next location in the token stream,
-// calling Inc to move beyond the "end" of the token stream is an error
-// and results in a panic
-func (t *tokeniser) Inc() {
-	t.loc++
-}
-
-// Seek moves the location of the scanner to some arbitrary point in the
-// location of the scanner to some arbitrary point in the past
-func (t *tokeniser) Seek(loc int) {
-	if loc < 0 || loc > len(t.tokens) {
-		panic(types.ErrBadLoc)
-	}
-	t.loc = loc
-}
-
-// Peek returns the Token at the current location of the tokeniser
-// without actually advancing the location. Peak also returns the flag
-// ok, indicating whether or not we reached the end of the input
-func (t *tokeniser) Peek() (token types.Token, ok bool) {
-	if t.loc >= len(t.tokens) {
-		category := t.scanner.Scan()
-		if category == scanner.EOF {
-			return
-		}
-		t.tokens = append(
-			t.tokens,
-			types.NewToken(category, t.scanner.TokenText()),
-		)
-	}
-	return t.tokens[t.loc], true
-}
-
 // Seq returns a chainable parser which applies parsers in sequence to
 // the input token stream. That is, it applies the first parser a to the
 // input, and for each finishing location, applies the next parser,
@@ -127,14 +64,10 @@ func (t *tokeniser) Peek() (token types.Token, ok bool) {
 func Seq(n string, p types.Parser) Chain {
 	return types.NewM(func(c Chain, s types.Tokeniser) (r types.Result) {
 		switch c.Result().(type) {
-		case types.Failed:
+		case types.Failed, types.Halt:
 			r = c.Result()
 		case types.Succeeded:
-			r = types.NewFailed("")
-		}
-		for loc := range c.Result().Locs() {
-			s.Seek(loc)
-			r = r.Join(c.Parse(s))
+			r = parseEach(s, c)
 		}
 		return
 	}).WithName(n).Chain(p)
@@ -190,9 +123,13 @@ func SeqString(name string) Chain {
 // Is a parser which parsers any of the inputs "a", "b", or "c"
 func Either(n string, p types.Parser) Chain {
 	return types.NewM(func(c Chain, s types.Tokeniser) (r types.Result) {
+		if _, ok := c.Result().(types.Halt); ok {
+			return c.Result()
+		}
+		s.Seek(c.Start())
 		r = c.Result().Join(c.Parse(s))
 		return
-	}).WithName(n).Chain(p)
+	}).WithName(n).Alternation().Chain(p)
 
 }
 
@@ -235,3 +172,2313 @@ func EitherFloat(name string) Chain {
 func EitherString(name string) Chain {
 	return Either(name, types.String())
 }
+
+// Choice returns a chainable parser which tries each of ps as Either
+// does, without chaining each alternative on in turn, so that:
+//
+//	Choice("literal", types.Int(), types.Float(), types.String())
+//
+// Is equivalent to:
+//
+//	Either("literal", types.Int()).
+//		Chain(types.Float()).
+//		Chain(types.String())
+//
+// When every alternative fails for want of a token, the names they
+// expected are gathered into the single error "expected one of: " and
+// the names, in the order of ps. A halt in any alternative still halts
+// Choice. With no ps there is nothing to choose, Choice always fails
+// with an error saying so
+func Choice(n string, ps ...types.Parser) Chain {
+	if len(ps) == 0 {
+		return Seq(n, types.Func(func(t types.Tokeniser) types.Result {
+			name := n
+			if name == "" {
+				name = "choice"
+			}
+			return types.NewFailedAt(name+" has no alternatives", peekPos(t))
+		}))
+	}
+	either := Either(n, ps[0])
+	for _, p := range ps[1:] {
+		either = either.Chain(p)
+	}
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		r := either.Parse(t)
+		if _, ok := r.(types.Failed); !ok {
+			return r
+		}
+		var names []string
+		seen := map[string]bool{}
+		for _, err := range r.Errors() {
+			name := err.Expected()
+			if name == "" {
+				return r
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		if len(names) < 2 {
+			return r
+		}
+		return types.NewFailed("one of: " + strings.Join(names, ", "))
+	}))
+}
+
+// Sequence returns a chainable parser which applies each of ps in turn
+// as Seq does, without chaining each parser on in turn, succeeding with
+// the values of ps collected into a []any. So:
+//
+//	Sequence("assign", types.Ident(), types.Text('='), types.Int())
+//
+// Parses the same input as:
+//
+//	Seq("assign", types.Ident()).
+//		Chain(types.Text('=')).
+//		Chain(types.Int())
+//
+// And succeeds with a value such as [x = 1]. With no parsers Sequence
+// succeeds at once with an empty slice
+func Sequence(n string, ps ...types.Parser) Chain {
+	return Seq(n, sequence("", ps, []any{}))
+}
+
+// Many returns a chainable parser which applies the parser p to the
+// input token stream zero or more times, greedily, collecting the value
+// of each application into a []any. Many always succeeds, so when p
+// fails at once, including at the end of the input, the result is an
+// empty slice at the starting location. A required parser which hits
+// the end of the input still fails as usual, it is only the absence of
+// optional input which Many absorbs
+func Many(n string, p types.Parser) Chain {
+	return Seq(n, types.Func(func(t types.Tokeniser) types.Result {
+		vs := []any{}
+		r := types.NewSucceeded(vs, t.Loc())
+		for {
+			next := parseFrom(types.Unstream(t), r, p)
+			if _, ok := next.(types.Halt); ok {
+				return next
+			}
+			if _, ok := next.(types.Succeeded); !ok || !progressed(r, next) {
+				return types.NewSucceededLocs(vs, r.Locs())
+			}
+			var halt types.Result
+			if vs, halt = collect(t, vs, next, earliest(r)); halt != nil {
+				return halt
+			}
+			r = next
+		}
+	}))
+}
+
+// parseFrom applies the parser p at every location at which the
+// succeeded result r finished, joining the results together
+func parseFrom(t types.Tokeniser, r types.Result, p types.Parser) (next types.Result) {
+	for loc := range r.Locs() {
+		t.Seek(loc)
+		if next == nil {
+			next = p.Parse(t)
+		} else {
+			next = next.Join(p.Parse(t))
+		}
+	}
+	return
+}
+
+// parseEach continues the chain c at every location at which its
+// succeeded result finished, with the value the result has at that
+// location, joining the results together
+func parseEach(t types.Tokeniser, c Chain) (next types.Result) {
+	r := c.Result().(types.Succeeded)
+	defer c.WithResult(r)
+	for loc := range r.Locs() {
+		t.Seek(loc)
+		c.WithResult(r.WithValue(r.ValueAt(loc)))
+		if next == nil {
+			next = c.Parse(t)
+		} else {
+			next = next.Join(c.Parse(t))
+		}
+	}
+	return
+}
+
+// collect appends the value of the element r, which began at the
+// location start, to the list vs, unless t is a stream, in which case
+// the value is emitted instead. If emitting the value fails, collect
+// returns a Halt located after the element as its second value
+func collect(t types.Tokeniser, vs []any, r types.Result, start int) ([]any, types.Result) {
+	ok, err := types.Emit(t, r.Value(), start)
+	switch {
+	case !ok:
+		return append(vs, r.Value()), nil
+	case err != nil:
+		return vs, types.NewHalt("emit", err, types.Pos{Loc: t.Loc()})
+	}
+	return vs, nil
+}
+
+// progressed reports whether the result b finished at any location
+// that the result a did not, that is, whether the parser which produced
+// b consumed any input since a. Repeating combinators use this to stop
+// looping on parsers which succeed without consuming anything
+func progressed(a, b types.Result) bool {
+	for loc := range b.Locs() {
+		if _, ok := a.Locs()[loc]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// furthest returns the furthest of the locations at which the succeeded
+// result r finished
+func furthest(r types.Result) (end int) {
+	for loc := range r.Locs() {
+		end = max(end, loc)
+	}
+	return
+}
+
+// earliest returns the earliest of the locations at which the succeeded
+// result r finished
+func earliest(r types.Result) (start int) {
+	start = -1
+	for loc := range r.Locs() {
+		if start < 0 || loc < start {
+			start = loc
+		}
+	}
+	return
+}
+
+// window is a Tokeniser which restricts another Tokeniser to the tokens
+// before the location limit, to a parser reading through a window the
+// token stream appears to end at limit
+type window struct {
+	types.Tokeniser
+
+	// limit is the location of the first token outside
+	// of the window
+	limit int
+}
+
+// Unwrap returns the Tokeniser the window restricts
+func (w window) Unwrap() types.Tokeniser {
+	return w.Tokeniser
+}
+
+// Inc moves the window to the next location in the token stream,
+// calling Inc to move beyond the end of the window results in a panic
+func (w window) Inc() {
+	if w.Loc() >= w.limit {
+		panic(types.ErrBadLoc)
+	}
+	w.Tokeniser.Inc()
+}
+
+// Peek returns the Token at the current location of the window, Peek
+// reports the end of the input for any location beyond the window
+func (w window) Peek() (token types.Token, ok bool) {
+	if w.Loc() >= w.limit {
+		return
+	}
+	return w.Tokeniser.Peek()
+}
+
+// Within returns a chainable parser which applies p to the input token
+// stream but forbids it from consuming more than n tokens. To p, the
+// input appears to end n tokens after the starting location, so p fails
+// if it requires more tokens than the window allows
+func Within(n int, p types.Parser) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		return p.Parse(window{t, t.Loc() + n})
+	}))
+}
+
+// SepBy returns a chainable parser which applies the parser elem zero
+// or more times, with the parser sep applied between each application,
+// collecting the values of elem into a []any. The values of sep are
+// dropped, as is any trailing separator
+func SepBy(n string, elem, sep types.Parser) Chain {
+	return SepByAny(n, elem, sep)
+}
+
+// SepByAny is like SepBy but accepts any one of the separator parsers
+// first and more between elements, for example either a comma or a
+// newline
+func SepByAny(n string, elem, first types.Parser, more ...types.Parser) Chain {
+	sep := Either("", first)
+	for _, s := range more {
+		sep = sep.Chain(s)
+	}
+
+	return Seq(n, types.Func(func(t types.Tokeniser) types.Result {
+		start := t.Loc()
+		r := elem.Parse(types.Unstream(t))
+		switch r.(type) {
+		case types.Failed:
+			return types.NewSucceeded([]any{}, start)
+		case types.Halt:
+			return r
+		}
+		vs, halt := collect(t, []any{}, r, start)
+		if halt != nil {
+			return halt
+		}
+		for {
+			s := parseFrom(types.Unstream(t), r, sep)
+			switch s.(type) {
+			case types.Failed:
+				return types.NewSucceededLocs(vs, r.Locs())
+			case types.Halt:
+				return s
+			}
+			next := parseFrom(types.Unstream(t), s, elem)
+			if _, ok := next.(types.Halt); ok {
+				return next
+			}
+			if _, ok := next.(types.Succeeded); !ok || !progressed(r, next) {
+				return types.NewSucceededLocs(vs, r.Locs())
+			}
+			if vs, halt = collect(t, vs, next, earliest(s)); halt != nil {
+				return halt
+			}
+			r = next
+		}
+	}))
+}
+
+// SepByN is like SepBy but fails, with an error positioned at the
+// start of the list, unless the number of elements is at least min and
+// at most max, for example for the arguments of a function which takes
+// one to three of them
+func SepByN(n string, elem, sep types.Parser, min, max int) Chain {
+	list := SepBy("", elem, sep)
+	return Seq(n, types.Func(func(t types.Tokeniser) types.Result {
+		pos := peekPos(t)
+		r := list.Parse(t)
+		if _, ok := r.(types.Succeeded); !ok {
+			return r
+		}
+		if count := len(r.Value().([]any)); count < min || count > max {
+			return types.NewFailedAt(fmt.Sprintf(
+				"expected %d to %d elements, got %d", min, max, count,
+			), pos)
+		}
+		return r
+	}))
+}
+
+// Framed is like SepBy but allows the list to begin and to end with
+// frame, each optionally, as in a table row written either "| a | b |"
+// or "a | b". The values of frame are dropped along with those of sep
+func Framed(n string, frame, elem, sep types.Parser) Chain {
+	return Seq(n, optional(frame, nil)).
+		Chain(SepBy("", elem, sep)).
+		Passthrough(optional(frame, nil))
+}
+
+// Between returns a chainable parser which parses open, p and then
+// close, succeeding with the value of p alone, as for a parenthesised
+// expression. If close fails, the failure is positioned where close was
+// expected and names the token which opened the construct and where, so
+// that an unmatched bracket can be traced back to its opening
+func Between(n string, open, p, close types.Parser) Chain {
+	opened := Seq("", open).Chain(p)
+	return Seq(n, open).Chain(p).Passthrough(close).MapFailed(func(t types.Tokeniser, start int, r types.Result) types.Result {
+		t.Seek(start)
+		opening, _ := t.Peek()
+		before := opened.Parse(t)
+		if _, ok := before.(types.Succeeded); !ok {
+			return r
+		}
+		return types.NewFailedAt(fmt.Sprintf(
+			"%s, to close %s opened at %s", formatFailed(r), opening.Match(), opening.Pos(),
+		), posOf(t, furthest(before)))
+	})
+}
+
+// Pair is an entry of an OrderedMap, a key and its value
+type Pair struct {
+	Key   any
+	Value any
+}
+
+// OrderedMap is the value of an OrderedMapOf parser, the pairs of a map
+// in the order their keys first appeared in the input, so that formats
+// such as JSON objects can be reproduced as written
+type OrderedMap struct {
+	Pairs []Pair
+
+	// index is the index into Pairs of each key
+	index map[any]int
+}
+
+// Get returns the value of the key key, if m has one
+func (m OrderedMap) Get(key any) (v any, ok bool) {
+	i, ok := m.index[key]
+	if !ok {
+		return nil, false
+	}
+	return m.Pairs[i].Value, true
+}
+
+// Keys returns the keys of m in order
+func (m OrderedMap) Keys() []any {
+	keys := make([]any, len(m.Pairs))
+	for i, pair := range m.Pairs {
+		keys[i] = pair.Key
+	}
+	return keys
+}
+
+// OrderedMapOf returns a chainable parser which parses pairs of a key
+// and a value separated by sep, with pairSep between each pair, into an
+// OrderedMap, e.g. the pairs b=1,a=2 with the separators = and ,. The
+// keys have to be comparable. A key which appears again replaces the
+// value of the first, which keeps its place in the order
+func OrderedMapOf(n string, key, value, sep, pairSep types.Parser) Chain {
+	pair := Seq("", key).Lazy(func(k any) Parser {
+		return Seq("", sep).Chain(value).Return(func(v any) any {
+			return Pair{k, v}
+		})
+	})
+	return SepBy(n, pair, pairSep).Return(func(v any) any {
+		m := OrderedMap{index: map[any]int{}}
+		for _, p := range v.([]any) {
+			p := p.(Pair)
+			if i, ok := m.index[p.Key]; ok {
+				m.Pairs[i].Value = p.Value
+				continue
+			}
+			m.index[p.Key] = len(m.Pairs)
+			m.Pairs = append(m.Pairs, p)
+		}
+		return m
+	})
+}
+
+// Path returns a chainable parser which parses a key path made up of an
+// identifier followed by any sequence of ".ident" and "[int]" segments,
+// such as a.b[0].c, into a []any of its string and int64 components. A
+// dangling "." or "[" which does not begin a well formed segment fails
+// the whole path
+func Path() Chain {
+	segment := Either("", Seq("", types.Text('.')).Chain(types.Ident())).
+		Chain(Seq("", types.Text('[')).Chain(types.Int()).Text(']'))
+
+	return Seq("path", types.Ident()).
+		Lazy(func(v any) Parser {
+			return Many("", segment).Return(func(vs any) any {
+				return append([]any{v}, vs.([]any)...)
+			})
+		}).
+		Passthrough(types.Func(func(t types.Tokeniser) types.Result {
+			start := t.Loc()
+			for _, p := range []Parser{types.Text('.'), types.Text('[')} {
+				t.Seek(start)
+				if _, ok := p.Parse(t).(types.Succeeded); ok {
+					return types.NewFailed("path segment")
+				}
+			}
+			t.Seek(start)
+			return types.NewSucceeded(nil, start)
+		}))
+}
+
+// Exactly returns a chainable parser which applies p to the input token
+// stream and succeeds only if p finished exactly n tokens after the
+// starting location, failing otherwise. This guards rules of fixed
+// layout formats against consuming more or less than expected
+func Exactly(n int, p types.Parser) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		end := t.Loc() + n
+		r := p.Parse(t)
+		if _, ok := r.(types.Succeeded); !ok {
+			return r
+		}
+		if _, ok := r.Locs()[end]; !ok {
+			return types.NewFailed(fmt.Sprintf("%d tokens", n))
+		}
+		return types.NewSucceeded(r.Value(), end)
+	}))
+}
+
+// Count returns a chainable parser which applies p exactly times times
+// in sequence, collecting the values into a []any of length times, as
+// for the four hex digits of an escape sequence. If p fails before
+// matching times times, Count fails with the number of occurrences it
+// expected and found. A times of zero succeeds at once with an empty
+// slice, like Empty, a negative times fails
+func Count(n string, times int, p types.Parser) Chain {
+	name := p.Name()
+	if name == "" {
+		name = n
+	}
+	return Seq(n, types.Func(func(t types.Tokeniser) types.Result {
+		if times < 0 {
+			return types.NewFailedAt(fmt.Sprintf("negative count %d of %s", times, name), peekPos(t))
+		}
+		vs := make([]any, 0, times)
+		r := types.NewSucceeded(vs, t.Loc())
+		for i := 0; i < times; i++ {
+			next := parseFrom(types.Unstream(t), r, p)
+			switch next.(type) {
+			case types.Halt:
+				return next
+			case types.Failed:
+				return types.NewFailed(fmt.Sprintf("%d occurrences of %s, found %d", times, name, i))
+			}
+			var halt types.Result
+			if vs, halt = collect(t, vs, next, earliest(r)); halt != nil {
+				return halt
+			}
+			r = next
+		}
+		return types.NewSucceededLocs(vs, r.Locs())
+	}))
+}
+
+// Until returns a chainable parser which applies body repeatedly until
+// terminator matches, consuming the terminator and collecting the values
+// of body into a []any. Unlike Many, Until stops on the terminator rather
+// than on body failing, so if body fails, including at the end of the
+// input, before the terminator is found then Until fails
+func Until(n string, body, terminator types.Parser) Chain {
+	return until(n, body, terminator, true)
+}
+
+// ManyLazy returns a chainable parser which applies p repeatedly, like
+// Many, but only until follow matches, collecting the values of p into a
+// []any. Where Many is greedy, ManyLazy prefers the shortest repetition
+// after which follow can match, as for the regular expression *?. Unlike
+// Until, follow is not consumed, it is left for whatever is chained next
+func ManyLazy(n string, p, follow types.Parser) Chain {
+	return until(n, p, follow, false)
+}
+
+// until applies body repeatedly until terminator matches, see Until,
+// consuming the terminator only if consume is set
+func until(n string, body, terminator types.Parser, consume bool) Chain {
+	return Seq(n, types.Func(func(t types.Tokeniser) types.Result {
+		vs := []any{}
+		r := types.NewSucceeded(vs, t.Loc())
+		for {
+			end := parseFrom(types.Unstream(t), r, terminator)
+			switch end.(type) {
+			case types.Succeeded:
+				if !consume {
+					return types.NewSucceededLocs(vs, r.Locs())
+				}
+				return types.NewSucceededLocs(vs, end.Locs())
+			case types.Halt:
+				return end
+			}
+			next := parseFrom(types.Unstream(t), r, body)
+			switch next.(type) {
+			case types.Failed:
+				return end.Join(next)
+			case types.Halt:
+				return next
+			}
+			if !progressed(r, next) {
+				return end
+			}
+			var halt types.Result
+			if vs, halt = collect(t, vs, next, earliest(r)); halt != nil {
+				return halt
+			}
+			r = next
+		}
+	}))
+}
+
+// OpList returns a chainable parser which parses one or more operand
+// separated by op, returning the flat []any of both the operand and op
+// values in the order they were parsed, i.e. [v0, op0, v1, op1, v2].
+// Unlike an operator fold, no precedence or associativity is applied
+func OpList(operand, op types.Parser) Chain {
+	pair := Seq("", op).Lazy(func(o any) Parser {
+		return Seq("", operand).Return(func(v any) any {
+			return []any{o, v}
+		})
+	})
+	return Seq("", operand).Lazy(func(v any) Parser {
+		return Many("", pair).Return(func(ps any) any {
+			vs := []any{v}
+			for _, p := range ps.([]any) {
+				vs = append(vs, p.([]any)...)
+			}
+			return vs
+		})
+	})
+}
+
+// Chainl returns a chainable parser which parses one or more operand
+// separated by op, folding the values of the operands left
+// associatively with the values of op, each a func(a, b any) any. So
+// 1 - 2 - 3 is folded as (1 - 2) - 3
+func Chainl(n string, operand, op types.Parser) Chain {
+	return fold(n, operand, op, func(vs []any) (any, error) {
+		acc := vs[0]
+		for i := 1; i < len(vs); i += 2 {
+			f, err := operator(vs[i])
+			if err != nil {
+				return nil, err
+			}
+			acc = f(acc, vs[i+1])
+		}
+		return acc, nil
+	})
+}
+
+// Chainr is like Chainl but folds the operands right associatively, so
+// 2 ^ 3 ^ 2 is folded as 2 ^ (3 ^ 2)
+func Chainr(n string, operand, op types.Parser) Chain {
+	return fold(n, operand, op, func(vs []any) (any, error) {
+		acc := vs[len(vs)-1]
+		for i := len(vs) - 2; i > 0; i -= 2 {
+			f, err := operator(vs[i])
+			if err != nil {
+				return nil, err
+			}
+			acc = f(vs[i-1], acc)
+		}
+		return acc, nil
+	})
+}
+
+// operator returns the value of an operator parsed by Chainl or Chainr
+// as the function it should be
+func operator(v any) (func(a, b any) any, error) {
+	f, ok := v.(func(a, b any) any)
+	if !ok {
+		return nil, fmt.Errorf("operator value %v is a %T, not a func(a, b any) any", v, v)
+	}
+	return f, nil
+}
+
+// fold returns a chainable parser which parses one or more operand
+// separated by op, succeeding with the value reduce returns for the
+// values of both in the order they were parsed, see OpList. Each
+// location at which the list finishes is folded from the values parsed
+// on the way to it, if reduce fails the parser halts with the component
+// "fold"
+func fold(n string, operand, op types.Parser, reduce func(vs []any) (any, error)) Chain {
+	return Seq(n, types.Func(func(t types.Tokeniser) types.Result {
+		start := t.Loc()
+		r := operand.Parse(t)
+		s, ok := r.(types.Succeeded)
+		if !ok {
+			return r
+		}
+
+		// lists holds the values parsed on the way to each
+		// location the list may yet continue from
+		lists := map[int][]any{}
+		for loc := range s.Locs() {
+			lists[loc] = []any{s.ValueAt(loc)}
+		}
+		ends := map[int][]any{}
+		for len(lists) > 0 {
+			next := map[int][]any{}
+			for loc, vs := range lists {
+				extended, halt := foldStep(t, loc, vs, operand, op, next)
+				if halt != nil {
+					return halt
+				}
+				if !extended {
+					ends[loc] = vs
+				}
+			}
+			lists = next
+		}
+
+		var folded types.Result
+		for loc, vs := range ends {
+			v, err := reduce(vs)
+			if err != nil {
+				return types.NewHalt("fold", err, posOf(t, start))
+			}
+			if folded == nil {
+				folded = types.NewSucceeded(v, loc)
+			} else {
+				folded = folded.Join(types.NewSucceeded(v, loc))
+			}
+		}
+		return folded
+	}))
+}
+
+// foldStep parses an op and an operand after the list vs, which finished
+// at loc, adding each longer list to next by the location it finishes
+// at. It reports whether the list was extended, or the Halt either
+// parser returned
+func foldStep(t types.Tokeniser, loc int, vs []any, operand, op types.Parser, next map[int][]any) (bool, types.Result) {
+	t.Seek(loc)
+	r := op.Parse(t)
+	if r.Kind() == types.HaltKind {
+		return false, r
+	}
+	o, ok := r.(types.Succeeded)
+	if !ok {
+		return false, nil
+	}
+	extended := false
+	for oloc := range o.Locs() {
+		t.Seek(oloc)
+		r = operand.Parse(t)
+		if r.Kind() == types.HaltKind {
+			return false, r
+		}
+		a, ok := r.(types.Succeeded)
+		if !ok {
+			continue
+		}
+		for aloc := range a.Locs() {
+			if aloc <= loc {
+				continue
+			}
+			next[aloc] = append(vs[:len(vs):len(vs)], o.ValueAt(oloc), a.ValueAt(aloc))
+			extended = true
+		}
+	}
+	return extended, nil
+}
+
+// If returns a chainable parser which applies then if cond is true and
+// otherwise otherwise, the choice is made once when the parser is
+// constructed. This lets one grammar support multiple versions of a
+// language which are fixed in advance
+func If(cond bool, then, otherwise types.Parser) Chain {
+	if cond {
+		return Seq("", then)
+	}
+	return Seq("", otherwise)
+}
+
+// IfEnv is like If but chooses between then and otherwise each time the
+// parser is applied, according to pred called with the environment of
+// the parse as returned by the Tokeniser
+func IfEnv(pred func(env any) bool, then, otherwise types.Parser) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		if pred(t.Env()) {
+			return then.Parse(t)
+		}
+		return otherwise.Parse(t)
+	}))
+}
+
+// pair returns a parser which parses key, then sep, then value,
+// returning the key and value as a [2]any
+func pair(key, value, sep types.Parser) Chain {
+	return Seq("", key).Lazy(func(k any) Parser {
+		return Seq("", sep).Chain(value).Return(func(v any) any {
+			return [2]any{k, v}
+		})
+	})
+}
+
+// Pairs returns a chainable parser which parses zero or more key value
+// pairs, the key and value separated by sep and the pairs separated by
+// between, returning a [][2]any of the pairs. The order of the pairs is
+// preserved, as are any duplicate keys
+func Pairs(n string, key, value, sep, between types.Parser) Chain {
+	return SepBy(n, pair(key, value, sep), between).Return(func(vs any) any {
+		ps := make([][2]any, 0, len(vs.([]any)))
+		for _, v := range vs.([]any) {
+			ps = append(ps, v.([2]any))
+		}
+		return ps
+	})
+}
+
+// Recover returns a chainable parser which applies p and, if p fails,
+// recovers by skipping tokens until one belonging to any of the lexical
+// categories sync, or the end of the input. The skipped tokens are
+// consumed but the sync token is not, and the value of the recovered
+// result is the Failed result of p. If no sync categories are given and
+// p is a Chain, the FOLLOW set of p declared with Follow is used
+func Recover(p types.Parser, sync ...rune) Chain {
+	if m, ok := p.(Chain); ok && len(sync) == 0 {
+		sync = m.FollowSet()
+	}
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		start := t.Loc()
+		r := p.Parse(t)
+		if _, ok := r.(types.Failed); !ok {
+			return r
+		}
+		return types.NewSucceeded(r, resync(t, start, func(t types.Tokeniser) bool {
+			for _, category := range sync {
+				if lookahead(t, types.Text(category)) {
+					return true
+				}
+			}
+			return false
+		}))
+	}))
+}
+
+// resync skips tokens from the location start until sync reports that
+// the token at the current location is a point to resynchronise at, or
+// until the end of the input, returning the location it stopped at
+func resync(t types.Tokeniser, start int, sync func(types.Tokeniser) bool) int {
+	for loc := start; ; loc++ {
+		t.Seek(loc)
+		if _, ok := t.Peek(); !ok || sync(t) {
+			return loc
+		}
+	}
+}
+
+// lookahead reports whether p succeeds at the current location of t,
+// without consuming any input
+func lookahead(t types.Tokeniser, p types.Parser) bool {
+	loc := t.Loc()
+	defer t.Seek(loc)
+	_, ok := p.Parse(t).(types.Succeeded)
+	return ok
+}
+
+// Factor returns a chainable parser which parses the prefix common once
+// and then tries each of the alternatives first and more after it,
+// returning the value of the alternative which succeeded. This left
+// factors alternatives which share a common prefix, so
+//
+//	Either("", Seq("", a).Chain(b)).Chain(Seq("", a).Chain(c))
+//
+// Which parses a twice, can be written as Factor(a, b, c)
+func Factor(common, first types.Parser, more ...types.Parser) Chain {
+	suffix := Either("", first)
+	for _, p := range more {
+		suffix = suffix.Chain(p)
+	}
+	return Seq("", common).Chain(suffix)
+}
+
+// Commented is the value of a WithLeadingComment parser, the value of
+// the wrapped parser together with the comment which preceded it
+type Commented struct {
+	Comment string
+	Value   any
+}
+
+// WithLeadingComment returns a chainable parser which applies p and
+// attaches the most recent comment preceding the input p parsed to its
+// value, returning a Commented. This requires a tokeniser which keeps
+// comments as trivia, see WithTrivia, otherwise the comment is empty
+func WithLeadingComment(p types.Parser) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		var comment string
+		if token, ok := t.Peek(); ok && len(token.Leading()) > 0 {
+			comment = token.Leading()[len(token.Leading())-1]
+		}
+		r := p.Parse(t)
+		return r.WithValue(Commented{comment, r.Value()})
+	}))
+}
+
+// Permutation returns a chainable parser which parses each of the
+// parsers required exactly once, in any order, returning their values
+// as a []any indexed by the position of the parser in required rather
+// than the order they were parsed in. Permutation fails if any of
+// required is missing, or if any appears more than once
+func Permutation(n string, required ...types.Parser) Chain {
+	return Seq(n, types.Func(func(t types.Tokeniser) types.Result {
+		vs := make([]any, len(required))
+		done := make([]bool, len(required))
+		r := types.NewSucceeded(vs, t.Loc())
+
+		for range required {
+			i, next := permute(t, r, required, done)
+			switch next.(type) {
+			case types.Halt:
+				return next
+			case types.Failed:
+				if duplicated(t, r, required) {
+					return types.NewFailed("at most one of each of " + n)
+				}
+				return next
+			}
+			vs[i], done[i] = next.Value(), true
+			r = next
+		}
+		if duplicated(t, r, required) {
+			return types.NewFailed("at most one of each of " + n)
+		}
+		return types.NewSucceededLocs(vs, r.Locs())
+	}))
+}
+
+// permute applies each of the parsers ps not yet done from the result
+// r, returning the index and result of the first to succeed, or the
+// failure of the first parser not done if none do
+func permute(t types.Tokeniser, r types.Result, ps []types.Parser, done []bool) (int, types.Result) {
+	var failed types.Result
+	for i, p := range ps {
+		if done[i] {
+			continue
+		}
+		switch next := parseFrom(t, r, p); next.(type) {
+		case types.Succeeded, types.Halt:
+			return i, next
+		default:
+			if failed == nil {
+				failed = next
+			}
+		}
+	}
+	return -1, failed
+}
+
+// duplicated reports whether any of ps succeeds from the result r
+func duplicated(t types.Tokeniser, r types.Result, ps []types.Parser) bool {
+	for _, p := range ps {
+		if _, ok := parseFrom(t, r, p).(types.Succeeded); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Records returns a chainable parser which parses zero or more rows
+// separated by rowSep, returning a []any of the row values. A row which
+// fails to parse does not abort the records, instead the remainder of
+// the row up to the next rowSep is skipped and the Failed result of the
+// row is recorded in place of its value
+func Records(n string, row, rowSep types.Parser) Chain {
+	return SepBy(n, recoverAt(row, rowSep), rowSep)
+}
+
+// recoverAt returns a parser which applies p and, if p fails, recovers
+// by skipping tokens up to the next location at which sync succeeds, or
+// the end of the input, with the Failed result of p as its value. A p
+// which fails directly at sync recovers without skipping anything, only
+// at the end of the input does recoverAt fail with the result of p
+// instead, so that a list of rows ends there
+func recoverAt(p, sync types.Parser) types.Parser {
+	return types.Func(func(t types.Tokeniser) types.Result {
+		start := t.Loc()
+		r := p.Parse(t)
+		if _, ok := r.(types.Failed); !ok {
+			return r
+		}
+		end := resync(t, start, func(t types.Tokeniser) bool {
+			return lookahead(t, sync)
+		})
+		if _, ok := t.Peek(); !ok && end == start {
+			return r
+		}
+		return types.NewSucceeded(r, end)
+	})
+}
+
+// Statements is the value of a Program parser, the values of the
+// statements which parsed successfully and the failures of those which
+// did not, each in the order they appeared
+type Statements struct {
+	Stmts  []any
+	Errors []types.Failed
+}
+
+// Program returns a chainable parser which parses a sequence of stmt
+// each terminated by stmtEnd, where the final terminator is optional. A
+// statement which fails to parse does not abort the program, instead the
+// parser recovers at the next stmtEnd, so the value, a Statements,
+// collects both the statements and the errors of a whole program
+func Program(stmt, stmtEnd types.Parser) Chain {
+	statement := Seq("", recoverAt(stmt, stmtEnd)).Passthrough(optional(stmtEnd, nil))
+	return Many("program", statement).Return(func(vs any) any {
+		var s Statements
+		for _, v := range vs.([]any) {
+			if f, ok := v.(types.Failed); ok {
+				s.Errors = append(s.Errors, f)
+			} else {
+				s.Stmts = append(s.Stmts, v)
+			}
+		}
+		return s
+	})
+}
+
+// Must returns the value of the result r if r succeeded, and otherwise
+// panics with the reasons r failed. Must is intended for tests and for
+// parsers whose failure is a programming error, mirroring the likes of
+// regexp.MustCompile, e.g.
+//
+//	v := Must[int64](p.Parse(t))
+//
+// A Deferred value is forced first, Must also panics if the value of r
+// is not a V
+func Must[V any](r types.Result) V {
+	var v V
+	r = types.Force(r)
+	if _, ok := r.(types.Succeeded); !ok {
+		panic(errors.New(formatFailed(r)))
+	}
+	if r.Value() == nil {
+		return v
+	}
+	v, ok := r.Value().(V)
+	if !ok {
+		panic(fmt.Errorf("unexpected value %v of type %T", r.Value(), r.Value()))
+	}
+	return v
+}
+
+// formatFailed formats the reasons the result r did not succeed as a
+// single message
+func formatFailed(r types.Result) string {
+	switch r.Kind() {
+	case types.HaltKind:
+		return r.(types.Halt).Error()
+	case types.FailedKind:
+		msgs := make([]string, 0, len(r.Errors()))
+		for _, err := range r.Errors() {
+			msgs = append(msgs, err.Error())
+		}
+		return strings.Join(msgs, "; ")
+	}
+	return ""
+}
+
+// Optional returns a chainable parser which applies p and, if p fails,
+// succeeds instead with the value def at each location it began from,
+// so whatever is chained after it carries on from where p would have
+// begun. Optional never fails itself, although a Halt from p still
+// propagates. Unlike an Either with an Empty alternative the default is
+// kept apart from whatever value p would have produced
+func Optional[V any](n string, p types.Parser, def V) Chain {
+	return Seq(n, optional(p, def))
+}
+
+// optional returns a parser which applies p and, if p fails, succeeds
+// instead at the starting location with the value def
+func optional(p types.Parser, def any) types.Parser {
+	return types.Func(func(t types.Tokeniser) types.Result {
+		start := t.Loc()
+		r := p.Parse(t)
+		if _, ok := r.(types.Failed); ok {
+			return types.NewSucceeded(def, start)
+		}
+		return r
+	})
+}
+
+// Branch is a single branch of a conditional parsed by Conditional, the
+// values of its condition and body
+type Branch struct {
+	Cond any
+	Body any
+}
+
+// IfElse is the value of a Conditional parser. Branches holds the if
+// branch followed by any elif branches in the order they were parsed,
+// Else holds the value of the body of the else branch, if HasElse
+type IfElse struct {
+	Branches []Branch
+	Else     any
+	HasElse  bool
+}
+
+// Conditional returns a chainable parser which parses an if/elif/else
+// chain made up of an ifKw branch, followed by zero or more elifKw
+// branches and an optional elseKw body. Each if and elif branch is the
+// keyword followed by cond and then body, the value is an IfElse
+func Conditional(ifKw, elifKw, elseKw, cond, body types.Parser) Chain {
+	branch := func(kw types.Parser) Chain {
+		return Seq("", kw).Chain(cond).Lazy(func(c any) Parser {
+			return Seq("", body).Return(func(b any) any {
+				return Branch{c, b}
+			})
+		})
+	}
+	type present struct{ v any }
+	els := optional(Seq("", elseKw).Chain(body).Return(func(v any) any {
+		return present{v}
+	}), nil)
+
+	return Seq("conditional", branch(ifKw)).Lazy(func(first any) Parser {
+		return Many("", branch(elifKw)).Lazy(func(elifs any) Parser {
+			return Seq("", els).Return(func(e any) any {
+				v := IfElse{Branches: []Branch{first.(Branch)}}
+				for _, b := range elifs.([]any) {
+					v.Branches = append(v.Branches, b.(Branch))
+				}
+				if e, ok := e.(present); ok {
+					v.Else, v.HasElse = e.v, true
+				}
+				return v
+			})
+		})
+	})
+}
+
+// Suffixes returns a chainable parser which parses base followed by any
+// sequence of the suffixes, greedily, folding the value of each suffix
+// into the value of base in the order they were parsed using apply. For
+// example an array suffix [] and a nullable suffix ? might be applied
+// to the base type int as int[]?. With no suffixes Suffixes parses base
+// alone
+func Suffixes(base types.Parser, apply func(v, suffix any) any, suffixes ...types.Parser) Chain {
+	if len(suffixes) == 0 {
+		return Seq("", base)
+	}
+	suffix := Either("", suffixes[0])
+	for _, p := range suffixes[1:] {
+		suffix = suffix.Chain(p)
+	}
+	return Seq("", base).Lazy(func(v any) Parser {
+		return Many("", suffix).Return(func(ss any) any {
+			for _, s := range ss.([]any) {
+				v = apply(v, s)
+			}
+			return v
+		})
+	})
+}
+
+// Tree is the value of a Block parser, the value of the header and the
+// values of the items indented beneath it
+type Tree struct {
+	Header   any
+	Children []any
+}
+
+// Block returns a chainable parser which parses a header followed by an
+// optional indented sequence of items, returning a Tree. An item may
+// itself be a Block, so nested indentation parses into nested trees.
+// Block requires a tokeniser which tracks indentation, see
+// WithIndentation
+func Block(header, item types.Parser) Chain {
+	children := Seq("", types.Text(types.Indent)).
+		Chain(Many("", item)).
+		Text(types.Dedent)
+
+	return Seq("block", header).Lazy(func(h any) Parser {
+		return Seq("", optional(children, nil)).Return(func(vs any) any {
+			tree := Tree{Header: h}
+			if vs != nil {
+				tree.Children = vs.([]any)
+			}
+			return tree
+		})
+	})
+}
+
+// Element returns a chainable parser which parses a pair of matching
+// tags enclosing any number of children, such as of a markup language,
+// succeeding with a Tree of the value of openTag and the values of
+// child. The value of closeTag has to match that of openTag, a close tag
+// which does not fails with an error positioned at it referring back to
+// the open tag
+func Element(openTag, closeTag, child types.Parser) Chain {
+	return Seq("element", types.Func(func(t types.Tokeniser) types.Result {
+		pos := peekPos(t)
+		return Seq("", openTag).Lazy(func(open any) Parser {
+			return Seq("", Many("", child)).Lazy(func(children any) Parser {
+				return types.Func(func(t types.Tokeniser) types.Result {
+					closePos := peekPos(t)
+					r := types.Force(closeTag.Parse(t))
+					if _, ok := r.(types.Succeeded); !ok {
+						return r
+					}
+					if fmt.Sprint(r.Value()) != fmt.Sprint(open) {
+						return types.NewFailedAt(fmt.Sprintf(
+							"closing tag %v does not match tag %v opened at %s", r.Value(), open, pos,
+						), closePos)
+					}
+					return r.WithValue(Tree{Header: open, Children: children.([]any)})
+				})
+			})
+		}).Parse(t)
+	}))
+}
+
+// Tagged returns a chainable parser for a discriminated union, which
+// parses discriminator and then dispatches to the parser in cases keyed
+// by the value of the discriminator, returning the value of that case.
+// Tagged fails if the discriminator has no case
+func Tagged(discriminator types.Parser, cases map[any]types.Parser) Chain {
+	tags := make([]string, 0, len(cases))
+	for tag := range cases {
+		tags = append(tags, fmt.Sprint(tag))
+	}
+	sort.Strings(tags)
+	unknown := types.Func(func(types.Tokeniser) types.Result {
+		return types.NewFailed("one of " + strings.Join(tags, ", "))
+	})
+
+	return Seq("tagged", discriminator).Lazy(func(tag any) Parser {
+		if p, ok := cases[tag]; ok {
+			return p
+		}
+		return unknown
+	})
+}
+
+// peekPos returns the position of the token at the current location of
+// t, or just the location if t is at the end of the input
+func peekPos(t types.Tokeniser) types.Pos {
+	if token, ok := t.Peek(); ok {
+		return token.Pos()
+	}
+	return types.Pos{Loc: t.Loc()}
+}
+
+// failAt returns a parser which always fails with the message msg
+// positioned at pos
+func failAt(msg string, pos types.Pos) Parser {
+	return types.Func(func(types.Tokeniser) types.Result {
+		return types.NewFailedAt(msg, pos)
+	})
+}
+
+// Checked returns a chainable parser which parses field and then body,
+// and succeeds with the value of body only if check returns true for the
+// values of the two. Otherwise Checked fails with the message msg,
+// positioned at the start of field. This validates self describing
+// formats, such as a length prefix which has to match its body
+func Checked(field, body types.Parser, check func(fieldVal, bodyVal any) bool, msg string) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		pos := peekPos(t)
+		return Seq("", field).Lazy(func(f any) Parser {
+			return Seq("", body).Lazy(func(b any) Parser {
+				if check(f, b) {
+					return types.NewEmpty(b)
+				}
+				return failAt(msg, pos)
+			})
+		}).Parse(t)
+	}))
+}
+
+// Unique returns a chainable parser which parses like the list parser
+// p but fails, with the message msg positioned at the element, if the
+// key of any element is the key of an element before it. key returns
+// the key of an element, which has to be comparable, such as the name
+// of a field of a struct. The elements are those of the value of p, a
+// slice or an OrderedMap, which Unique returns unchanged. An element is
+// positioned where it began if p reports its elements as Many and SepBy
+// do, otherwise at the start of the list
+func Unique(n string, p types.Parser, key func(v any) any, msg string) Chain {
+	return Seq(n, types.Func(func(t types.Tokeniser) types.Result {
+		var (
+			start  = t.Loc()
+			starts []int
+			seen   = map[any]types.None{}
+		)
+		r := p.Parse(types.Observe(types.Unstream(t), func(loc int) {
+			starts = append(starts, loc)
+		}))
+		if _, ok := r.(types.Succeeded); !ok {
+			return r
+		}
+		vs := elements(r.Value())
+		for i, v := range vs {
+			k := key(v)
+			if _, ok := seen[k]; ok {
+				if len(starts) == len(vs) {
+					start = starts[i]
+				}
+				return types.NewFailedAt(msg, posOf(t, start))
+			}
+			seen[k] = types.None{}
+		}
+		return r
+	}))
+}
+
+// elements returns the elements of the list v, a slice, an array or an
+// OrderedMap, or nil if v is not a list
+func elements(v any) []any {
+	if m, ok := v.(OrderedMap); ok {
+		vs := make([]any, len(m.Pairs))
+		for i, pair := range m.Pairs {
+			vs[i] = pair
+		}
+		return vs
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil
+	}
+	vs := make([]any, rv.Len())
+	for i := range vs {
+		vs[i] = rv.Index(i).Interface()
+	}
+	return vs
+}
+
+// posOf returns the position of the token at the location loc of t,
+// leaving t where it was
+func posOf(t types.Tokeniser, loc int) types.Pos {
+	cur := t.Loc()
+	defer t.Seek(cur)
+	t.Seek(loc)
+	return peekPos(t)
+}
+
+// isBlank reports whether c is a space or tab, whitespace which
+// separates words on the same line
+func isBlank(c rune) bool {
+	return c == ' ' || c == '\t'
+}
+
+// Args returns a chainable parser which parses a line of shell style
+// arguments, each either a quoted string or a bare word, a run of
+// characters other than whitespace, separated by spaces or tabs. Args
+// stops at the end of the line without consuming the newline and its
+// value is the arguments as a []string. Args requires a Tokeniser which
+// emits the input a character at a time but scans strings:
+//
+//	NewTokeniser(r).WithMode(scanner.ScanStrings).WithWhitespace(0)
+func Args() Chain {
+	blanks := optional(types.Chars(isBlank), nil)
+	word := types.Chars(func(c rune) bool {
+		return c >= 0 && !unicode.IsSpace(c)
+	})
+	arg := Seq("", blanks).Chain(EitherString("argument").Chain(word))
+	return Many("args", arg).
+		Passthrough(blanks).
+		Return(func(v any) any {
+			args := make([]string, len(v.([]any)))
+			for i, arg := range v.([]any) {
+				args[i] = arg.(string)
+			}
+			return args
+		})
+}
+
+// CharClassLiteral returns a chainable parser which parses a regular
+// expression style character class, such as [a-z0-9_], into a predicate
+// func(rune) bool reporting whether a character belongs to the class.
+// A class is a bracketed list of characters and inclusive ranges of
+// characters, negated by a leading ^. Any character can be escaped by a
+// backslash, a ] has to be. CharClassLiteral requires a Tokeniser which
+// emits the input a character at a time
+func CharClassLiteral() Chain {
+	single := func(pred func(rune) bool) Parser {
+		return Within(1, types.Chars(pred)).Return(func(v any) any {
+			return []rune(v.(string))[0]
+		})
+	}
+	char := Either("character", single(func(c rune) bool {
+		return c >= 0 && c != ']' && c != '\\'
+	})).Chain(Seq("", types.Text('\\')).Chain(single(func(c rune) bool {
+		return c >= 0
+	})))
+	item := Seq("", char).Lazy(func(lo any) Parser {
+		hi := Seq("", types.Text('-')).Chain(char)
+		return Seq("", optional(hi, lo)).Return(func(hi any) any {
+			return [2]rune{lo.(rune), hi.(rune)}
+		})
+	})
+	return Seq("character class", types.Text('[')).
+		Chain(optional(types.Text('^'), nil)).
+		Lazy(func(negated any) Parser {
+			return Many("", item).Text(']').Return(func(v any) any {
+				ranges := v.([]any)
+				return func(c rune) bool {
+					for _, r := range ranges {
+						if r := r.([2]rune); r[0] <= c && c <= r[1] {
+							return negated == nil
+						}
+					}
+					return negated != nil
+				}
+			})
+		})
+}
+
+// WithWhitespace returns a chainable parser which applies p with the
+// whitespace of the input made significant or not for the duration, the
+// set of characters the Tokeniser skips is restored once p has parsed.
+// Significant whitespace is emitted a character at a time, otherwise
+// the Tokeniser skips scanner.GoWhitespace. Alternatives which read the
+// same input with different whitespace should not both succeed, the
+// locations at which the one finished are not valid for the other
+func WithWhitespace(significant bool, p types.Parser) Chain {
+	ws := uint64(scanner.GoWhitespace)
+	if significant {
+		ws = 0
+	}
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		t.PushWhitespace(ws)
+		defer t.PopWhitespace()
+		return p.Parse(t)
+	}))
+}
+
+// Lexer is a set of rules by which the input is scanned into tokens, see
+// types.Lexer
+type Lexer = types.Lexer
+
+// WithLexer returns a chainable parser which applies region with the
+// input scanned by the rules of lex for the duration, the rules of the
+// Tokeniser are restored once region has parsed. This lets a region of
+// the input such as an embedded language be scanned by its own rules
+// rather than those of the surrounding grammar. As with WithWhitespace,
+// tokens scanned by other rules are scanned again as they are peeked at
+func WithLexer(region types.Parser, lex Lexer) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		t.PushLexer(&lex)
+		defer t.PopLexer()
+		return region.Parse(t)
+	}))
+}
+
+// snapshot is a read only Tokeniser over tokens scanned in advance,
+// which any number of parsers can read concurrently through copies of
+// their own. The set of characters skipped is fixed when the tokens are
+// scanned, so the whitespace of a snapshot cannot be changed
+type snapshot struct {
+	tokens []types.Token
+	loc    int
+	env    any
+	err    error
+}
+
+// take returns a snapshot of every token of t, positioned at the current
+// location of t, leaving t where it was
+func take(t types.Tokeniser) *snapshot {
+	start := t.Loc()
+	s := &snapshot{loc: start, env: t.Env()}
+	t.Seek(0)
+	for token, ok := t.Peek(); ok; token, ok = t.Peek() {
+		s.tokens = append(s.tokens, token)
+		t.Inc()
+	}
+	s.err = t.Err()
+	t.Seek(start)
+	return s
+}
+
+// region returns a snapshot of the tokens of t at the locations from up
+// to but not including to, or the end of the input if that is sooner,
+// as a token stream of their own which begins at location 0. The
+// snapshot ends as the input does, its error is ErrEOF
+func region(t types.Tokeniser, from, to int) *snapshot {
+	start := t.Loc()
+	s := &snapshot{env: t.Env(), err: types.ErrEOF}
+	t.Seek(0)
+	for loc := 0; loc < to; loc++ {
+		token, ok := t.Peek()
+		if !ok {
+			break
+		}
+		if loc >= from {
+			pos := token.Pos()
+			pos.Loc -= from
+			s.tokens = append(s.tokens, token.WithPos(pos))
+		}
+		t.Inc()
+	}
+	t.Seek(start)
+	return s
+}
+
+func (s *snapshot) Loc() int {
+	return s.loc
+}
+
+func (s *snapshot) Dec() {
+	s.loc--
+}
+
+func (s *snapshot) Inc() {
+	s.loc++
+}
+
+func (s *snapshot) Seek(loc int) {
+	if loc < 0 || loc > len(s.tokens) {
+		panic(types.ErrBadLoc)
+	}
+	s.loc = loc
+}
+
+func (s *snapshot) Peek() (token types.Token, ok bool) {
+	if s.loc >= len(s.tokens) {
+		return
+	}
+	return s.tokens[s.loc], true
+}
+
+func (s *snapshot) Env() any {
+	return s.env
+}
+
+func (s *snapshot) Err() error {
+	return s.err
+}
+
+// PushWhitespace does nothing, see snapshot
+func (*snapshot) PushWhitespace(uint64) {}
+
+// PopWhitespace does nothing, see snapshot
+func (*snapshot) PopWhitespace() {}
+
+// PushLexer does nothing, the tokens of a snapshot are already scanned
+func (*snapshot) PushLexer(*types.Lexer) {}
+
+// PopLexer does nothing, see PushLexer
+func (*snapshot) PopLexer() {}
+
+// Race returns a chainable parser which applies every one of ps to the
+// input text concurrently, each to its own snapshot of the token stream,
+// and succeeds with the result of whichever succeeds first. If none of
+// ps succeeds the results are joined as for Either. The input is scanned
+// to the end before any of ps begins, and the others are left to finish
+// in the background once one has succeeded, so ps should not have side
+// effects
+func Race(ps ...types.Parser) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		s := take(t)
+		results := make(chan types.Result, len(ps))
+		for _, p := range ps {
+			go func(p types.Parser, s snapshot) {
+				results <- p.Parse(&s)
+			}(p, *s)
+		}
+		var r types.Result = types.Failed{}
+		for range ps {
+			next := <-results
+			if _, ok := next.(types.Succeeded); ok {
+				return next
+			}
+			r = r.Join(next)
+		}
+		return r
+	}))
+}
+
+// MaxAmbiguity returns a chainable parser which parses like p but halts
+// if p finishes at more than n locations, with a Halt whose component is
+// "ambiguity". This is a middle ground between an ambiguous grammar and
+// a Strict one, for grammars where a bounded degree of ambiguity is
+// expected but any more indicates a bug
+func MaxAmbiguity(n int, p types.Parser) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		pos := peekPos(t)
+		r := p.Parse(t)
+		if len(r.Locs()) > n {
+			return types.NewHalt("ambiguity", fmt.Errorf(
+				"%w: %d interpretations, at most %d allowed",
+				types.ErrAmbiguous, len(r.Locs()), n,
+			), pos)
+		}
+		return r
+	}))
+}
+
+// Evaluator receives the events of a parse by Expression and produces
+// its value, decoupling the grammar of an expression from its meaning,
+// so the same grammar can evaluate, pretty print or build a tree
+type Evaluator interface {
+	// OnNumber is called with the value of each number,
+	// an int64 or float64
+	OnNumber(n any) any
+
+	// OnGroup is called with the value of the expression
+	// inside each pair of parentheses
+	OnGroup(v any) any
+
+	// OnBinaryOp is called with the values of the left
+	// and right operands of each binary operator op
+	OnBinaryOp(op string, a, b any) any
+}
+
+// Expression returns a chainable parser which parses an arithmetic
+// expression of numbers, parenthesised groups and left associative
+// binary operators, calling the methods of e as each is recognised. The
+// operators are given by levels, in increasing order of precedence, each
+// level a string of the single character operators sharing it, e.g.
+//
+//	Expression(e, "+-", "*/")
+//
+// An empty level has no operators and is skipped
+func Expression(e Evaluator, levels ...string) Chain {
+	var expr Chain
+	group := SeqText("group", '(').
+		Lazy(func(any) Parser {
+			return expr
+		}).
+		Text(')').
+		Return(e.OnGroup)
+	var operand types.Parser = Either("operand", Seq("", types.Int()).Return(e.OnNumber)).
+		Chain(Seq("", types.Float()).Return(e.OnNumber)).
+		Chain(group)
+
+	for i := len(levels) - 1; i >= 0; i-- {
+		ops := []rune(levels[i])
+		if len(ops) == 0 {
+			continue
+		}
+		op := Either("operator", types.Text(ops[0]))
+		for _, c := range ops[1:] {
+			op = op.Chain(types.Text(c))
+		}
+		operand = OpList(operand, op).Return(func(v any) any {
+			vs := v.([]any)
+			acc := vs[0]
+			for i := 1; i < len(vs); i += 2 {
+				acc = e.OnBinaryOp(vs[i].(string), acc, vs[i+1])
+			}
+			return acc
+		})
+	}
+	expr = Seq("expression", operand)
+	return expr
+}
+
+// Anchors is a parse environment which holds the values registered by
+// Anchor by name, for Alias to look up. Any other environment which
+// implements the methods of Anchors can be used in its place
+type Anchors map[string]any
+
+// SetAnchor registers the value v under the name name
+func (a Anchors) SetAnchor(name string, v any) {
+	a[name] = v
+}
+
+// Anchor returns the value registered under the name name, if any
+func (a Anchors) Anchor(name string) (v any, ok bool) {
+	v, ok = a[name]
+	return
+}
+
+// anchors is the interface of an environment which holds anchors
+type anchors interface {
+	SetAnchor(name string, v any)
+	Anchor(name string) (any, bool)
+}
+
+// envAnchors returns the anchors held by the environment of t, or a Halt
+// if the environment does not hold any
+func envAnchors(t types.Tokeniser) (anchors, types.Result) {
+	a, ok := t.Env().(anchors)
+	if !ok {
+		return nil, types.NewHalt("anchor", fmt.Errorf(
+			"environment %T does not hold anchors", t.Env(),
+		), peekPos(t))
+	}
+	return a, nil
+}
+
+// Anchor returns a chainable parser which parses the name of an anchor
+// with name and then p, registering the value of p under the name in the
+// environment of the parse, see Anchors, for a later Alias to resolve.
+// The value is that of p. Registering is a side effect which is not
+// undone if the parse later backtracks
+func Anchor(name, p types.Parser) Chain {
+	return Seq("anchor", name).Lazy(func(n any) Parser {
+		return types.Func(func(t types.Tokeniser) types.Result {
+			a, halt := envAnchors(t)
+			if halt != nil {
+				return halt
+			}
+			r := types.Force(p.Parse(t))
+			if _, ok := r.(types.Succeeded); ok {
+				a.SetAnchor(fmt.Sprint(n), r.Value())
+			}
+			return r
+		})
+	})
+}
+
+// Alias returns a chainable parser which parses the name of an anchor
+// with name and succeeds with the value registered under it by Anchor,
+// failing with an error positioned at the alias if there is none
+func Alias(name types.Parser) Chain {
+	return Seq("alias", types.Func(func(t types.Tokeniser) types.Result {
+		pos := peekPos(t)
+		return Seq("", name).Lazy(func(n any) Parser {
+			return types.Func(func(t types.Tokeniser) types.Result {
+				a, halt := envAnchors(t)
+				if halt != nil {
+					return halt
+				}
+				v, ok := a.Anchor(fmt.Sprint(n))
+				if !ok {
+					return types.NewFailedAt(fmt.Sprintf("undefined anchor %v", n), pos)
+				}
+				return types.NewSucceeded(v, t.Loc())
+			})
+		}).Parse(t)
+	}))
+}
+
+// Option is the value of a Maybe parser, Value is the value of the
+// parser if it was Present
+type Option struct {
+	Value   any
+	Present bool
+}
+
+// Maybe returns a chainable parser which applies p if it can, always
+// succeeding with an Option which records whether p matched. Unlike
+// defaulting a missing value this keeps apart a value which is absent
+// from one which is present but zero
+func Maybe(n string, p types.Parser) Chain {
+	present := Seq("", p).Return(func(v any) any {
+		return Option{v, true}
+	})
+	return Seq(n, optional(present, Option{}))
+}
+
+// Span is the lexical category of a token matched by a parse and where
+// it begins and ends in the input text
+type Span struct {
+	Category   rune
+	Start, End types.Pos
+}
+
+// Highlighted is the value of a Highlight parser, the value of the
+// parser it wraps and the spans of the tokens it matched
+type Highlighted struct {
+	Value any
+	Spans []Span
+}
+
+// Highlight returns a chainable parser which parses like p but succeeds
+// with a Highlighted, the value of p along with a Span for every token p
+// matched in order, e.g. for syntax highlighting. Only the tokens of the
+// parse which succeeded are spanned, not those matched by alternatives
+// which were abandoned. If p finishes at more than one location the
+// spans run to the furthest
+func Highlight(p types.Parser) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		start := t.Loc()
+		r := types.Force(p.Parse(t))
+		if _, ok := r.(types.Succeeded); !ok {
+			return r
+		}
+		end := start
+		for loc := range r.Locs() {
+			end = max(end, loc)
+		}
+		h := Highlighted{Value: r.Value()}
+		for t.Seek(start); t.Loc() < end; t.Inc() {
+			token, _ := t.Peek()
+			h.Spans = append(h.Spans, Span{token.Category(), token.Pos(), token.End()})
+		}
+		return r.WithValue(h)
+	}))
+}
+
+// Fingerprinted is the value of a Fingerprint parser, the value of the
+// parser and a hash of the text of the tokens it matched
+type Fingerprinted struct {
+	Value any
+	Sum   uint64
+}
+
+// Fingerprint returns a chainable parser which parses like p but
+// succeeds with a Fingerprinted, the value of p along with a 64 bit FNV-1a
+// hash of the text of every token p matched. The hash depends only on
+// the tokens, not on the whitespace or comments between them, so tools
+// can use it to skip regions of the input which have not changed. If p
+// finishes at more than one location the hash runs to the furthest
+func Fingerprint(p types.Parser) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		start := t.Loc()
+		r := types.Force(p.Parse(t))
+		if _, ok := r.(types.Succeeded); !ok {
+			return r
+		}
+		h, end := fnv.New64a(), furthest(r)
+		for t.Seek(start); t.Loc() < end; t.Inc() {
+			token, _ := t.Peek()
+			h.Write([]byte(token.Match()))
+			h.Write([]byte{0})
+		}
+		return r.WithValue(Fingerprinted{r.Value(), h.Sum64()})
+	}))
+}
+
+// Node is a node of a concrete syntax tree built by CST, Name is the
+// name of the node and Value the value of the parser it was built from.
+// Children are the tokens and nested nodes the node spans, in order, as
+// a types.Token or a Node each
+type Node struct {
+	Name     string
+	Value    any
+	Children []any
+
+	// start and end are the locations of the first token
+	// of the node and of the token after its last
+	start, end int
+}
+
+// CST returns a chainable parser which parses like p but succeeds with a
+// Node spanning the tokens p matched. A Node found in the value of p, or
+// an []any within it, becomes a child of the new Node in place of its
+// tokens, so nesting CST parsers builds a tree. With a tokeniser which
+// keeps the source of tokens, see WithSource, the text of a node can be
+// reproduced exactly by Unparse
+func CST(name string, p types.Parser) Chain {
+	return Seq(name, types.Func(func(t types.Tokeniser) types.Result {
+		start := t.Loc()
+		r := types.Force(p.Parse(t))
+		if _, ok := r.(types.Succeeded); !ok {
+			return r
+		}
+		n := Node{Name: name, Value: r.Value(), start: start, end: start}
+		for loc := range r.Locs() {
+			n.end = max(n.end, loc)
+		}
+		nodes := childNodes(r.Value(), nil)
+		sort.Slice(nodes, func(i, j int) bool {
+			return nodes[i].start < nodes[j].start
+		})
+		loc := start
+		tokens := func(end int) {
+			for t.Seek(loc); t.Loc() < end; t.Inc() {
+				token, _ := t.Peek()
+				n.Children = append(n.Children, token)
+			}
+		}
+		for _, child := range nodes {
+			if child.start < loc || child.end > n.end {
+				continue
+			}
+			tokens(child.start)
+			n.Children = append(n.Children, child)
+			loc = child.end
+		}
+		tokens(n.end)
+		return r.WithValue(n)
+	}))
+}
+
+// childNodes appends the nodes in the value v to nodes, v being a Node
+// or an []any of them
+func childNodes(v any, nodes []Node) []Node {
+	switch v := v.(type) {
+	case Node:
+		nodes = append(nodes, v)
+	case []any:
+		for _, v := range v {
+			nodes = childNodes(v, nodes)
+		}
+	}
+	return nodes
+}
+
+// Unparse returns the input text spanned by the node n, reproduced from
+// the source of its tokens, see types.Token.Source. Unparse reproduces
+// the input exactly, including whitespace and comments before each
+// token, provided the tokens kept their source
+func Unparse(n Node) string {
+	var b strings.Builder
+	unparse(&b, n)
+	return b.String()
+}
+
+// unparse writes the source of the tokens of n to b
+func unparse(b *strings.Builder, n Node) {
+	for _, child := range n.Children {
+		switch child := child.(type) {
+		case types.Token:
+			b.WriteString(child.Source())
+		case Node:
+			unparse(b, child)
+		}
+	}
+}
+
+// Cut returns a chainable parser which commits to the alternative it is
+// part of before applying p, if p then fails no other alternative of the
+// enclosing Either is tried and the Either fails with the errors of p
+// alone. Placing a Cut once an alternative is certain, e.g. after its
+// keyword, keeps errors local to where the input went wrong rather than
+// reporting that no alternative matched. A cut outside of any Either
+// fails the parse with the errors of p, as if there were no cut
+func Cut(p types.Parser) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		pos := peekPos(t)
+		r := p.Parse(t)
+		if _, ok := r.(types.Failed); ok {
+			return types.NewCut(r, pos)
+		}
+		return r
+	}))
+}
+
+// Columns returns a chainable parser which parses a row of fixed width
+// data, slicing the line into fields of the character widths given by
+// widths and returning them as a []string. Each field has to be exactly
+// its width without running into the end of the line, which is not
+// consumed. Columns requires a Tokeniser which emits the input a
+// character at a time
+func Columns(widths ...int) Chain {
+	ps := make([]types.Parser, len(widths))
+	for i, w := range widths {
+		ps[i] = Exactly(w, Within(w, types.Chars(func(c rune) bool {
+			return c != '\n'
+		})))
+	}
+	return Seq("columns", sequence("columns", ps, nil)).Return(func(v any) any {
+		fields := make([]string, len(widths))
+		for i, field := range v.([]any) {
+			fields[i] = field.(string)
+		}
+		return fields
+	})
+}
+
+// Decoded returns a chainable parser which parses raw text with raw and
+// succeeds with the text passed through decode, e.g. to undo a custom
+// escaping scheme. If decode returns an error Decoded fails with its
+// message, positioned at the start of the raw text. The value of raw
+// has to be a string
+func Decoded(n string, raw types.Parser, decode func(string) (string, error)) Chain {
+	return Seq(n, types.Func(func(t types.Tokeniser) types.Result {
+		pos := peekPos(t)
+		r := types.Force(raw.Parse(t))
+		if _, ok := r.(types.Succeeded); !ok {
+			return r
+		}
+		s, err := decode(r.Value().(string))
+		if err != nil {
+			return types.NewFailedAt(err.Error(), pos)
+		}
+		return r.WithValue(s)
+	}))
+}
+
+// Range returns a chainable parser which parses a range, elem then sep
+// then elem again, such as 1..5 or a-z, returning the bounds as a [2]any.
+// If ordered is given, Range fails with an error positioned at the low
+// bound unless ordered reports that the low bound does not exceed the
+// high one
+func Range(elem, sep types.Parser, ordered ...func(lo, hi any) bool) Chain {
+	return Seq("range", types.Func(func(t types.Tokeniser) types.Result {
+		pos := peekPos(t)
+		return Seq("", elem).Lazy(func(lo any) Parser {
+			return Seq("", sep).Chain(elem).Lazy(func(hi any) Parser {
+				for _, ok := range ordered {
+					if !ok(lo, hi) {
+						return failAt(fmt.Sprintf("low bound %v exceeds high bound %v", lo, hi), pos)
+					}
+				}
+				return types.NewEmpty([2]any{lo, hi})
+			})
+		}).Parse(t)
+	}))
+}
+
+// Lookahead is a branch of an LLk parser, the lexical categories of the
+// tokens which select the branch and the parser which is then applied
+type Lookahead struct {
+	Prefix []rune
+	Parser types.Parser
+}
+
+// LLk returns a chainable parser which peeks at the next k tokens and
+// applies the parser of the first of branches whose Prefix matches their
+// lexical categories, without trying any other. A Prefix may be shorter
+// than k, matching any tokens which follow it. LLk fails if no branch
+// matches, so that an LL(k) production is dispatched deterministically
+// rather than by trying every alternative as Either does
+func LLk(k int, branches ...Lookahead) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		tokens := types.PeekN(t, k)
+		for _, b := range branches {
+			if matchesPrefix(tokens, b.Prefix) {
+				return b.Parser.Parse(t)
+			}
+		}
+		prefixes := make([]string, len(branches))
+		for i, b := range branches {
+			ss := make([]string, len(b.Prefix))
+			for j, c := range b.Prefix {
+				ss[j] = scanner.TokenString(c)
+			}
+			prefixes[i] = strings.Join(ss, " ")
+		}
+		return types.NewFailed("one of " + strings.Join(prefixes, ", "))
+	}))
+}
+
+// matchesPrefix reports whether the lexical categories of tokens begin
+// with prefix
+func matchesPrefix(tokens []types.Token, prefix []rune) bool {
+	if len(prefix) > len(tokens) {
+		return false
+	}
+	for i, c := range prefix {
+		if tokens[i].Category() != c {
+			return false
+		}
+	}
+	return true
+}
+
+// Combine returns a chainable parser which applies a and then b to the
+// input which follows, succeeding with the value f returns for the
+// values of the two. This joins the results of parsers of adjacent
+// regions, such as of a host language and a language embedded in it
+func Combine(a, b types.Parser, f func(av, bv any) any) Chain {
+	return Seq("", a).Lazy(func(av any) Parser {
+		return Seq("", b).Return(func(bv any) any {
+			return f(av, bv)
+		})
+	})
+}
+
+// End returns a chainable parser which succeeds, with no value and
+// consuming nothing, only at the end of the input, failing otherwise
+// with an error positioned at the token found there. Chained on to the
+// end of a grammar it asserts that the whole of the input was parsed,
+// Passthrough keeps the value of the grammar:
+//
+//	Seq("program", stmts).Passthrough(End())
+//
+// If the input ended because it could not be scanned, End halts with the
+// scanner error instead, see types.ScanHalt
+func End() Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		token, ok := t.Peek()
+		if h := types.ScanHalt(t); h != nil {
+			return h
+		}
+		if ok {
+			return types.NewFailedAt("expected end of input, got "+token.Match(), token.Pos())
+		}
+		return types.NewSucceeded(nil, t.Loc())
+	}))
+}
+
+// Not returns a chainable parser which succeeds without consuming any
+// input if p fails at the current location, and fails if p succeeds,
+// with an error naming n positioned at the token p would have parsed.
+// The location is restored either way. This expresses negation, such
+// as an identifier which is not a keyword:
+//
+//	Seq("name", Not("keyword", keywords)).Chain(types.Ident())
+//
+// A halt in p, such as a scanner error, halts Not rather than counting
+// as p failing
+func Not(n string, p types.Parser) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		loc := t.Loc()
+		pos := peekPos(t)
+		r := p.Parse(t)
+		t.Seek(loc)
+		switch r.(type) {
+		case types.Succeeded:
+			return types.NewFailedAt("unexpected "+n, pos)
+		case types.Halt:
+			return r
+		}
+		return types.NewSucceeded(nil, loc)
+	}))
+}
+
+// Expecting returns a chainable parser which parses like p but, if p
+// fails at the end of the input, fails instead with the single error
+// "unexpected end of input; expected " followed by what, positioned
+// just after the last token. This replaces the errors of whichever
+// terms happened to be tried at the end of a truncated input with a
+// description of what was missing
+func Expecting(what string, p types.Parser) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		r := p.Parse(t)
+		if _, ok := r.(types.Failed); !ok {
+			return r
+		}
+		for _, err := range r.Errors() {
+			if err.AtEnd() {
+				return types.NewFailedAt("unexpected end of input; expected "+what, err.End())
+			}
+		}
+		return r
+	}))
+}
+
+// Ternary returns a chainable parser which parses a conditional
+// expression, a cond followed by any number of qMark then colon else
+// suffixes, succeeding with the value build returns for the values of
+// the condition and the two branches. Conditionals nest to the right, so
+// "a ? b : c ? d : e" is parsed as "a ? b : (c ? d : e)". A cond with no
+// suffix succeeds with its own value
+func Ternary(cond, then, els, qMark, colon types.Parser, build func(c, t, e any) any) Chain {
+	var tail func(c any) Parser
+	nested := Seq("", els).Lazy(func(c any) Parser {
+		return tail(c)
+	})
+	tail = func(c any) Parser {
+		branches := Seq("", qMark).
+			Chain(then).
+			Passthrough(colon).
+			Lazy(func(t any) Parser {
+				return Seq("", nested).Return(func(e any) any {
+					return build(c, t, e)
+				})
+			})
+		return optional(branches, c)
+	}
+	return Seq("ternary", cond).Lazy(tail)
+}
+
+// Adjacent returns a chainable parser which applies a and then b, like
+// Seq("", a).Chain(b), but only where the first token of b immediately
+// follows the last token of a in the input text, with no whitespace or
+// comments between them. This distinguishes "a.b" from "a . b" in a
+// grammar whose tokeniser otherwise ignores whitespace, which must
+// record the offsets of its tokens, see Token.Offsets
+func Adjacent(a, b types.Parser) Chain {
+	adjacent := types.Func(func(t types.Tokeniser) types.Result {
+		loc := t.Loc()
+		next, ok := t.Peek()
+		if loc == 0 || !ok {
+			return types.NewSucceeded(nil, loc)
+		}
+		t.Seek(loc - 1)
+		prev, _ := t.Peek()
+		t.Seek(loc)
+		start, _ := next.Offsets()
+		if _, end := prev.Offsets(); end != start {
+			return types.NewFailedAt("unexpected space before "+next.Match(), next.Pos())
+		}
+		return types.NewSucceeded(nil, loc)
+	})
+	return Seq("", a).Chain(adjacent).Chain(b)
+}
+
+// Diagnostic is a warning about input which parsed successfully, such
+// as one a linter reports, and where it applies in the input text
+type Diagnostic struct {
+	Pos     types.Pos
+	Message string
+}
+
+// Diagnostics is a parse environment which accumulates the diagnostics
+// recorded by Warn. Any other environment which implements the methods
+// of Diagnostics can be used in its place
+type Diagnostics []Diagnostic
+
+// AddDiagnostic records the diagnostic d
+func (d *Diagnostics) AddDiagnostic(diag Diagnostic) {
+	*d = append(*d, diag)
+}
+
+// Diagnostics returns the diagnostics recorded so far, in the order they
+// were recorded
+func (d *Diagnostics) Diagnostics() []Diagnostic {
+	return *d
+}
+
+// diagnostics is the interface of an environment which accumulates
+// diagnostics
+type diagnostics interface {
+	AddDiagnostic(d Diagnostic)
+	Diagnostics() []Diagnostic
+}
+
+// envDiagnostics returns the diagnostics accumulated by the environment
+// of t, or a Halt if the environment does not accumulate any
+func envDiagnostics(t types.Tokeniser) (diagnostics, types.Result) {
+	d, ok := t.Env().(diagnostics)
+	if !ok {
+		return nil, types.NewHalt("diagnostic", fmt.Errorf(
+			"environment %T does not hold diagnostics", t.Env(),
+		), peekPos(t))
+	}
+	return d, nil
+}
+
+// Warn returns a chainable parser which parses like p and, when p
+// succeeds, calls check with its value, recording the Diagnostic check
+// returns in the environment of the parse, see Diagnostics. The parse
+// succeeds either way, a nil Diagnostic records nothing. A Diagnostic
+// without a position is positioned where p began. Recording is a side
+// effect which is not undone if the parse later backtracks
+func Warn(p types.Parser, check func(v any) *Diagnostic) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		d, halt := envDiagnostics(t)
+		if halt != nil {
+			return halt
+		}
+		pos := peekPos(t)
+		r := types.Force(p.Parse(t))
+		if _, ok := r.(types.Succeeded); !ok {
+			return r
+		}
+		if diag := check(r.Value()); diag != nil {
+			if diag.Pos.Line == 0 {
+				diag.Pos = pos
+			}
+			d.AddDiagnostic(*diag)
+		}
+		return r
+	}))
+}
+
+// ErrorProduction returns a chainable parser which parses a construct
+// known to be a mistake, such as = written where == was meant, so that
+// offered as an alternative within an Either it matches the mistake
+// rather than letting the whole parse fail with a generic error. When p
+// matches, the Diagnostic msg is recorded where p began, see Warn, and
+// the parse carries on with the value of p
+func ErrorProduction(p types.Parser, msg string) Chain {
+	return Warn(p, func(any) *Diagnostic {
+		return &Diagnostic{Message: msg}
+	})
+}
+
+// Linted is the value of a Lint parser, the value of the parser linted
+// and the diagnostics recorded while parsing it
+type Linted struct {
+	Value       any
+	Diagnostics []Diagnostic
+}
+
+// Lint returns a chainable parser which parses like p, succeeding with a
+// Linted holding the value of p alongside the diagnostics recorded by
+// any Warn within p, see Diagnostics
+func Lint(p types.Parser) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		d, halt := envDiagnostics(t)
+		if halt != nil {
+			return halt
+		}
+		before := len(d.Diagnostics())
+		r := types.Force(p.Parse(t))
+		if _, ok := r.(types.Succeeded); !ok {
+			return r
+		}
+		recorded := append([]Diagnostic(nil), d.Diagnostics()[before:]...)
+		return r.WithValue(Linted{r.Value(), recorded})
+	}))
+}
+
+// NonAssoc returns a chainable parser which parses exactly one operand,
+// an op and another operand, succeeding with the value build returns for
+// the values of the three. The operators of op are non-associative, so a
+// further op following the second operand, as in "a < b < c", fails
+// with an error positioned at it rather than leaving it unparsed
+func NonAssoc(operand, op types.Parser, build func(a, op, b any) any) Chain {
+	return Seq("", operand).Lazy(func(a any) Parser {
+		return Seq("", op).Lazy(func(o any) Parser {
+			return Seq("", operand).Lazy(func(b any) Parser {
+				return types.Func(func(t types.Tokeniser) types.Result {
+					loc, pos := t.Loc(), peekPos(t)
+					switch r := op.Parse(t); r.(type) {
+					case types.Succeeded:
+						return types.NewFailedAt(fmt.Sprintf(
+							"non-associative operator %v cannot follow %v", r.Value(), o,
+						), pos)
+					case types.Halt:
+						return r
+					}
+					t.Seek(loc)
+					return types.NewSucceeded(build(a, o, b), loc)
+				})
+			})
+		})
+	})
+}
+
+// trivia is a Tokeniser which skips whatever the parser skip matches
+// before each token, so that to a parser reading through it the trivia
+// skip matches does not appear in the token stream
+type trivia struct {
+	types.Tokeniser
+
+	skip types.Parser
+}
+
+// Unwrap returns the Tokeniser read through
+func (t trivia) Unwrap() types.Tokeniser {
+	return t.Tokeniser
+}
+
+// Peek returns the Token which follows any trivia at the current
+// location, without moving past the trivia
+func (t trivia) Peek() (token types.Token, ok bool) {
+	loc := t.Loc()
+	defer t.Seek(loc)
+	t.skipTrivia()
+	return t.Tokeniser.Peek()
+}
+
+// Inc moves past any trivia at the current location and then past the
+// Token which follows it
+func (t trivia) Inc() {
+	t.skipTrivia()
+	t.Tokeniser.Inc()
+}
+
+// skipTrivia moves past any trivia at the current location
+func (t trivia) skipTrivia() {
+	for {
+		loc := t.Loc()
+		r := t.skip.Parse(t.Tokeniser)
+		if _, ok := r.(types.Succeeded); !ok || !progressed(types.NewSucceeded(nil, loc), r) {
+			t.Seek(loc)
+			return
+		}
+		t.Seek(furthest(r))
+	}
+}
+
+// WithTrivia returns a chainable parser which parses like p but skips
+// anything skip matches, such as comments, before each token p reads,
+// so that p need not allow for trivia between every pair of terms. Any
+// trivia following the last token of p is left unparsed
+func WithTrivia(skip, p types.Parser) Chain {
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		return p.Parse(trivia{t, skip})
+	}))
+}
+
+// PrecedenceClimb returns a chainable parser which parses an expression
+// of operands parsed by atom joined by binary operators, by precedence
+// climbing. For each token following an operand ops returns the
+// precedence of the operator the token is, whether it is right
+// associative and the function which applies it to its left and right
+// operands, or a nil apply if the token is not an operator. Operators of
+// higher precedence bind more tightly. Unlike a layer of Chainl per level
+// of precedence, each operand is parsed once however many levels there
+// are
+func PrecedenceClimb(atom types.Parser, ops func(opTok string) (prec int, rightAssoc bool, apply func(a, b any) any)) Chain {
+	var climb func(t types.Tokeniser, min int) types.Result
+	climb = func(t types.Tokeniser, min int) types.Result {
+		r := types.Force(atom.Parse(t))
+		if _, ok := r.(types.Succeeded); !ok {
+			return r
+		}
+		lhs, end := r.Value(), furthest(r)
+		for {
+			t.Seek(end)
+			token, ok := t.Peek()
+			if !ok {
+				break
+			}
+			prec, right, apply := ops(token.Match())
+			if apply == nil || prec < min {
+				break
+			}
+			t.Inc()
+			next := prec + 1
+			if right {
+				next = prec
+			}
+			rhs := climb(t, next)
+			if _, ok := rhs.(types.Succeeded); !ok {
+				return rhs
+			}
+			lhs, end = apply(lhs, rhs.Value()), furthest(rhs)
+		}
+		return types.NewSucceeded(lhs, end)
+	}
+	return Seq("", types.Func(func(t types.Tokeniser) types.Result {
+		return climb(t, 0)
+	}))
+}
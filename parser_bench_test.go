@@ -0,0 +1,65 @@
+package llk_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"llk"
+	"llk/types"
+)
+
+// benchmark runs p over a fresh tokeniser of input b.N times, failing the
+// benchmark if p does not succeed
+func benchmark(b *testing.B, p llk.Parser, input string) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := p.Parse(llk.NewTokeniser(strings.NewReader(input)))
+		if _, ok := r.(types.Succeeded); !ok {
+			b.Fatalf("unexpected result %v", r)
+		}
+	}
+}
+
+func BenchmarkSeq(b *testing.B) {
+	p := llk.SeqId("", "a").Id("b").Id("c").Id("d").Id("e")
+	benchmark(b, p, "a b c d e")
+}
+
+func BenchmarkEither(b *testing.B) {
+	p := llk.EitherInt("").
+		Chain(types.Float()).
+		Chain(types.String()).
+		Chain(types.Ident())
+	benchmark(b, p, "a")
+}
+
+func BenchmarkNestedParens(b *testing.B) {
+	// <expr> → <int> | `(` <expr> `)`
+	var expr llk.Chain
+	expr = llk.EitherInt("expr").
+		Chain(llk.SeqText("", '(').
+			Lazy(func(any) llk.Parser {
+				return expr
+			}).
+			Text(')'))
+	benchmark(b, expr, strings.Repeat("(", 100)+"1"+strings.Repeat(")", 100))
+}
+
+func BenchmarkMany(b *testing.B) {
+	p := llk.Many("", types.Int())
+	benchmark(b, p, strings.Repeat("1 ", 1000))
+}
+
+func BenchmarkSepBy(b *testing.B) {
+	p := llk.SepBy("", types.Int(), types.Text(','))
+	benchmark(b, p, strings.Repeat("1,", 999)+"1")
+}
+
+func BenchmarkEitherKeywords(b *testing.B) {
+	p := llk.EitherId("", "kw0")
+	for i := 1; i < 100; i++ {
+		p = p.Chain(types.Id(fmt.Sprintf("kw%d", i)))
+	}
+	benchmark(b, p, "kw99")
+}
@@ -0,0 +1,101 @@
+package llk
+
+import (
+	"fmt"
+
+	"llk/types"
+)
+
+// Call is a single call a parser made on a Tokeniser, recorded by a
+// Recorder. Op is the name of the method called, one of "Inc", "Dec",
+// "Seek" or "Peek", and Loc is the location of the Tokeniser after the
+// call
+type Call struct {
+	Op  string
+	Loc int
+}
+
+// Recorder is a Tokeniser which records the calls a parser makes to
+// move around or peek at another Tokeniser, so that the exact access
+// pattern of a parse, including its backtracking, can be inspected or
+// replayed
+type Recorder struct {
+	types.Tokeniser
+
+	// trace is the sequence of calls made so far
+	trace []Call
+}
+
+// RecordTokeniser returns a Recorder which records every call made to
+// Inc, Dec, Seek and Peek on inner
+func RecordTokeniser(inner types.Tokeniser) *Recorder {
+	return &Recorder{Tokeniser: inner}
+}
+
+// Trace returns the calls recorded so far, in the order they were made
+func (r *Recorder) Trace() []Call {
+	return r.trace
+}
+
+// Unwrap returns the Tokeniser whose calls are recorded
+func (r *Recorder) Unwrap() types.Tokeniser {
+	return r.Tokeniser
+}
+
+// Dec moves the Tokeniser to the previous location and records the call
+func (r *Recorder) Dec() {
+	r.Tokeniser.Dec()
+	r.record("Dec")
+}
+
+// Inc moves the Tokeniser to the next location and records the call
+func (r *Recorder) Inc() {
+	r.Tokeniser.Inc()
+	r.record("Inc")
+}
+
+// Seek moves the Tokeniser to the location k and records the call
+func (r *Recorder) Seek(k int) {
+	r.Tokeniser.Seek(k)
+	r.record("Seek")
+}
+
+// Peek returns the Token at the current location and records the call
+func (r *Recorder) Peek() (types.Token, bool) {
+	token, ok := r.Tokeniser.Peek()
+	r.record("Peek")
+	return token, ok
+}
+
+// record appends a call of op at the current location to the trace
+func (r *Recorder) record(op string) {
+	r.trace = append(r.trace, Call{op, r.Tokeniser.Loc()})
+}
+
+// Replay makes the calls in trace on t, in order, and returns an error
+// at the first call which leaves t at a different location than was
+// recorded. Replaying the trace of a parse against a fresh Tokeniser
+// over the same input checks that the parse is reproducible
+func Replay(t types.Tokeniser, trace []Call) error {
+	for i, call := range trace {
+		switch call.Op {
+		case "Dec":
+			t.Dec()
+		case "Inc":
+			t.Inc()
+		case "Seek":
+			t.Seek(call.Loc)
+		case "Peek":
+			t.Peek()
+		default:
+			return fmt.Errorf("call %d: unknown operation %q", i, call.Op)
+		}
+		if t.Loc() != call.Loc {
+			return fmt.Errorf(
+				"call %d: %s finished at location %d, recorded %d",
+				i, call.Op, t.Loc(), call.Loc,
+			)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,603 @@
+package llk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/scanner"
+	"unicode/utf8"
+
+	"llk/types"
+)
+
+// tokeniser implements of the Tokeniser interface, emmiting tokens from
+// the scanner and storing scanned tokens in tokens
+type tokeniser struct {
+	scanner *scanner.Scanner
+
+	// tokens is the sequence of tokens already scanned
+	// by the tokeniser, the kth token to be scanned is
+	// stored at the kth index of tokens
+	tokens []types.Token
+
+	// loc is the "current" location of the tokeniser,
+	// returned by Loc()
+	loc int
+
+	// env is the user defined environment of the parse,
+	// returned by Env()
+	env any
+
+	// trivia indicates that comments are kept as the
+	// leading trivia of the following token rather than
+	// being skipped
+	trivia bool
+
+	// source indicates that each token keeps its exact
+	// input text, see types.Token.Source
+	source bool
+
+	// err is the last error encountered scanning the
+	// input, returned by Err()
+	err error
+
+	// indents is the stack of columns of the open levels
+	// of indentation, nil unless indentation is tracked
+	indents []int
+
+	// line is the line of the last token scanned while
+	// tracking indentation
+	line int
+
+	// reader is the input, from which the scanner is
+	// restarted to scan tokens again, and base is the
+	// position in the input at which it last started
+	reader *strings.Reader
+	base   scanner.Position
+
+	// whitespace is the stack of sets of characters the
+	// tokeniser skips, the top of which is in effect
+	whitespace []uint64
+
+	// lexers is the stack of rules by which the input is
+	// scanned in place of the tokeniser's own, the top
+	// of which is in effect if there are any
+	lexers []*types.Lexer
+
+	// batches records the state of the tokeniser before
+	// each call to scan, and batchOf the index of the
+	// batch which produced each token
+	batches []batch
+	batchOf []int
+
+	// lines is the input split into lines, kept the first
+	// time the source of a line is asked for
+	lines []string
+
+	// bom indicates that the input began with a byte
+	// order mark, which keepBOM indicates is emitted as a
+	// token rather than stripped
+	bom     bool
+	keepBOM bool
+}
+
+// batch is the state of the tokeniser before a call to scan, from which
+// the tokens appended by the call can be scanned again. offset, line and
+// column are the position in the input the call began scanning from, ws
+// is the set of characters skipped by the call
+type batch struct {
+	loc    int
+	offset int
+	line   int
+	column int
+
+	// indentLine and indents are the line and stack
+	// of the indentation tracking state
+	indentLine int
+	indents    []int
+
+	ws uint64
+
+	// lexer is the rules the call scanned by, nil for
+	// the tokeniser's own
+	lexer *types.Lexer
+}
+
+func NewTokeniser(r *strings.Reader) *tokeniser {
+	base := scanner.Position{Line: 1, Column: 1}
+	bom := hasBOM(r)
+	if bom {
+		if _, err := r.Seek(int64(len(byteOrderMark)), io.SeekStart); err != nil {
+			panic(types.ErrInternal)
+		}
+		base.Offset = len(byteOrderMark)
+	}
+	s := &scanner.Scanner{}
+	s.Init(r)
+
+	t := &tokeniser{
+		scanner:    s,
+		reader:     r,
+		base:       base,
+		whitespace: []uint64{s.Whitespace},
+		bom:        bom,
+	}
+	s.Error = t.error
+	return t
+}
+
+// byteOrderMark is the UTF-8 encoding of the byte order mark
+const byteOrderMark = "\uFEFF"
+
+// hasBOM reports whether the input of r begins with a byte order mark,
+// leaving r where it was
+func hasBOM(r *strings.Reader) bool {
+	b := make([]byte, len(byteOrderMark))
+	n, _ := r.ReadAt(b, 0)
+	return string(b[:n]) == byteOrderMark
+}
+
+// WithBOM makes the tokeniser treat a byte order mark at the beginning
+// of the input as significant, emitting it as a token of its own of the
+// lexical category '\uFEFF' for those formats which give it a meaning.
+// By default a leading byte order mark is stripped, so that it neither
+// reaches the grammar nor shifts the columns of the first line
+func (t *tokeniser) WithBOM() *tokeniser {
+	t.keepBOM = true
+	if t.bom {
+		t.base.Column++
+	}
+	return t
+}
+
+// NewTokeniserNormalized returns a tokeniser of the input read from r
+// after passing it through normalize, such as a unicode normalization
+// form, so that for example identifiers which differ only in how their
+// accents are encoded compare equal:
+//
+//	NewTokeniserNormalized(r, norm.NFC.String)
+//
+// The whole of the input is read before tokenising begins
+func NewTokeniserNormalized(r io.Reader, normalize func(string) string) (*tokeniser, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewTokeniser(strings.NewReader(normalize(string(b)))), nil
+}
+
+// ExpandVars returns a reader of the input read from r in which every
+// ${name} reference is replaced by the value lookup returns for name, for
+// NewTokeniser to tokenise, so a grammar sees the tokens of a value as
+// if they had been written in place of the reference:
+//
+//	r, err := ExpandVars(r, os.LookupEnv)
+//
+// A reference to a variable which lookup reports undefined, or one left
+// unclosed, is an error positioned at the reference. A lookup which
+// always reports a variable defined, with an empty value if need be,
+// expands undefined variables instead of rejecting them
+func ExpandVars(r io.Reader, lookup func(name string) (string, bool)) (*strings.Reader, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		text     = string(b)
+		expanded strings.Builder
+	)
+	for {
+		i := strings.Index(text, "${")
+		if i < 0 {
+			expanded.WriteString(text)
+			return strings.NewReader(expanded.String()), nil
+		}
+		expanded.WriteString(text[:i])
+		pos := offsetPos(string(b), len(b)-len(text)+i)
+		j := strings.IndexByte(text[i:], '}')
+		if j < 0 {
+			return nil, fmt.Errorf("%s: unclosed variable reference", pos)
+		}
+		name := text[i+2 : i+j]
+		v, ok := lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("%s: undefined variable %s", pos, name)
+		}
+		expanded.WriteString(v)
+		text = text[i+j+1:]
+	}
+}
+
+// offsetPos returns the line and column of the byte offset in text
+func offsetPos(text string, offset int) types.Pos {
+	before := text[:offset]
+	return types.Pos{
+		Line:   strings.Count(before, "\n") + 1,
+		Column: utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1,
+	}
+}
+
+// error records the error reported by the scanner with the message msg,
+// mapping scanner messages onto the package errors where they apply
+func (t *tokeniser) error(s *scanner.Scanner, msg string) {
+	var err error
+	switch msg {
+	case "invalid UTF-8 encoding", "invalid BOM in the middle of the file":
+		err = types.ErrBadCharset
+	default:
+		err = errors.New(msg)
+	}
+	if t.err == nil || t.err == types.ErrEOF {
+		t.err = fmt.Errorf("%s: %w", t.position(s.Position), err)
+	}
+}
+
+// Err returns the first error encountered scanning the input, otherwise
+// ErrEOF once the end of the input has been reached
+func (t tokeniser) Err() error {
+	return t.err
+}
+
+// WithWhitespace sets the set of characters skipped by the tokeniser
+// to ws, as for scanner.Scanner.Whitespace. Characters removed from the
+// set are emitted as tokens of their own, so for example the following
+// makes newlines significant:
+//
+//	NewTokeniser(r).WithWhitespace(scanner.GoWhitespace &^ (1 << '\n'))
+func (t *tokeniser) WithWhitespace(ws uint64) *tokeniser {
+	t.whitespace[0] = ws
+	return t
+}
+
+// PushWhitespace makes the tokeniser skip the set of characters ws in
+// place of the current set, until the matching call to PopWhitespace.
+// Tokens already scanned from the current location on are scanned again
+// with the set in effect when they are next peeked at
+func (t *tokeniser) PushWhitespace(ws uint64) {
+	t.whitespace = append(t.whitespace, ws)
+}
+
+// PopWhitespace restores the set of characters skipped by the tokeniser
+// to the set in effect before the last call to PushWhitespace. Calling
+// PopWhitespace without a matching PushWhitespace results in a panic
+func (t *tokeniser) PopWhitespace() {
+	if len(t.whitespace) == 1 {
+		panic(types.ErrInternal)
+	}
+	t.whitespace = t.whitespace[:len(t.whitespace)-1]
+}
+
+// PushLexer makes the tokeniser scan the input by the rules of lex in
+// place of its own, until the matching call to PopLexer. As with
+// PushWhitespace, tokens already scanned from the current location on
+// are scanned again by the rules in effect when they are next peeked at
+func (t *tokeniser) PushLexer(lex *types.Lexer) {
+	t.lexers = append(t.lexers, lex)
+}
+
+// PopLexer restores the rules by which the tokeniser scans the input to
+// those in effect before the last call to PushLexer. Calling PopLexer
+// without a matching PushLexer results in a panic
+func (t *tokeniser) PopLexer() {
+	if len(t.lexers) == 0 {
+		panic(types.ErrInternal)
+	}
+	t.lexers = t.lexers[:len(t.lexers)-1]
+}
+
+// lexer returns the rules by which the tokeniser currently scans the
+// input, or nil if it scans by its own
+func (t *tokeniser) lexer() *types.Lexer {
+	if len(t.lexers) == 0 {
+		return nil
+	}
+	return t.lexers[len(t.lexers)-1]
+}
+
+// ws returns the set of characters currently skipped by the tokeniser
+func (t *tokeniser) ws() uint64 {
+	return t.whitespace[len(t.whitespace)-1]
+}
+
+// WithEnv sets the user defined environment of the parse to env, the
+// environment is available to parsers through Env()
+func (t *tokeniser) WithEnv(env any) *tokeniser {
+	t.env = env
+	return t
+}
+
+// Env returns the user defined environment of the parse
+func (t tokeniser) Env() any {
+	return t.env
+}
+
+// WithMode sets the lexical elements recognised by the tokeniser to
+// mode, as for scanner.Scanner.Mode. Together with WithWhitespace this
+// can be used to tokenise the raw input text a character at a time:
+//
+//	NewTokeniser(r).WithMode(0).WithWhitespace(0)
+func (t *tokeniser) WithMode(mode uint) *tokeniser {
+	t.scanner.Mode = mode
+	return t
+}
+
+// WithTrivia makes the tokeniser keep the comments it would otherwise
+// skip, attaching them as leading trivia to the token which follows, see
+// Token.Leading. Comments are still never emitted as tokens of their own
+func (t *tokeniser) WithTrivia() *tokeniser {
+	t.trivia = true
+	t.scanner.Mode |= scanner.ScanComments
+	t.scanner.Mode &^= scanner.SkipComments
+	return t
+}
+
+// WithComments makes the tokeniser emit the comments it would otherwise
+// skip as tokens of their own, of the lexical category scanner.Comment,
+// so that a grammar can place them explicitly, see types.Comment
+func (t *tokeniser) WithComments() *tokeniser {
+	t.trivia = false
+	t.scanner.Mode |= scanner.ScanComments
+	t.scanner.Mode &^= scanner.SkipComments
+	return t
+}
+
+// WithSource makes the tokeniser keep the exact input text of every
+// token along with the whitespace and comments which precede it, see
+// types.Token.Source, so that the input can be reproduced from tokens
+func (t *tokeniser) WithSource() *tokeniser {
+	t.source = true
+	return t
+}
+
+// Loc returns the current location of the Tokeniser
+func (t tokeniser) Loc() int {
+	return t.loc
+}
+
+// Dec moves the Tokensier to the previous location in the token stream,
+// calling Dec to move before the "begning" of the token stream is an
+// error and results in a panic
+func (t *tokeniser) Dec() {
+	t.loc--
+}
+
+// Inc moves the Tokensier to the next location in the token stream,
+// calling Inc to move beyond the "end" of the token stream is an error
+// and results in a panic
+func (t *tokeniser) Inc() {
+	t.loc++
+}
+
+// Seek moves the location of the scanner to some arbitrary point in the
+// location of the scanner to some arbitrary point in the past
+func (t *tokeniser) Seek(loc int) {
+	if loc < 0 || loc > len(t.tokens) {
+		panic(types.ErrBadLoc)
+	}
+	t.loc = loc
+}
+
+// Peek returns the Token at the current location of the tokeniser
+// without actually advancing the location. Peak also returns the flag
+// ok, indicating whether or not we reached the end of the input
+func (t *tokeniser) Peek() (token types.Token, ok bool) {
+	if t.loc < len(t.tokens) {
+		if i := t.batchOf[t.loc]; t.batches[i].ws != t.ws() || t.batches[i].lexer != t.lexer() {
+			t.rewind(i)
+		}
+	}
+	if t.loc >= len(t.tokens) {
+		t.scan()
+	}
+	if t.loc >= len(t.tokens) {
+		return
+	}
+	return t.tokens[t.loc], true
+}
+
+// rewind discards the tokens produced by the ith batch and every batch
+// after it, restarting the scanner from where the batch began so that
+// the tokens are scanned again
+func (t *tokeniser) rewind(i int) {
+	b := t.batches[i]
+	t.tokens = t.tokens[:b.loc]
+	t.batchOf = t.batchOf[:b.loc]
+	t.batches = t.batches[:i]
+	t.line = b.indentLine
+	if t.indents != nil {
+		t.indents = append([]int(nil), b.indents...)
+	}
+	if t.err == types.ErrEOF {
+		t.err = nil
+	}
+
+	if _, err := t.reader.Seek(int64(b.offset), io.SeekStart); err != nil {
+		panic(types.ErrInternal)
+	}
+	s := t.scanner
+	mode, isIdentRune := s.Mode, s.IsIdentRune
+	s.Init(t.reader)
+	s.Mode, s.IsIdentRune, s.Error = mode, isIdentRune, t.error
+	t.base = scanner.Position{
+		Offset: b.offset,
+		Line:   b.line,
+		Column: b.column,
+	}
+}
+
+// position translates the position p reported by the scanner, relative
+// to where the scanner last started, into a position in the input
+func (t *tokeniser) position(p scanner.Position) scanner.Position {
+	if !p.IsValid() {
+		return p
+	}
+	if p.Line == 1 {
+		p.Column += t.base.Column - 1
+	}
+	p.Line += t.base.Line - 1
+	p.Offset += t.base.Offset
+	return p
+}
+
+// scan scans the next token from the input and appends it to tokens,
+// along with any synthetic tokens which precede it
+func (t *tokeniser) scan() {
+	p := t.position(t.scanner.Pos())
+	b := batch{
+		loc:        len(t.tokens),
+		offset:     p.Offset,
+		line:       p.Line,
+		column:     p.Column,
+		indentLine: t.line,
+		ws:         t.ws(),
+		lexer:      t.lexer(),
+	}
+	if t.indents != nil {
+		b.indents = append([]int(nil), t.indents...)
+	}
+	defer t.record(b)
+
+	t.scanner.Whitespace = b.ws
+	if b.lexer != nil {
+		s := t.scanner
+		mode, isIdentRune := s.Mode, s.IsIdentRune
+		s.Mode, s.Whitespace, s.IsIdentRune = b.lexer.Mode, b.lexer.Whitespace, b.lexer.IsIdentRune
+		defer func() {
+			s.Mode, s.IsIdentRune = mode, isIdentRune
+		}()
+	}
+	if b.loc == 0 && t.bom && t.keepBOM {
+		token := types.NewToken('\uFEFF', byteOrderMark).
+			WithPos(types.Pos{Line: 1, Column: 1}).
+			WithOffsets(0, len(byteOrderMark))
+		if t.source {
+			token = token.WithSource(byteOrderMark)
+		}
+		t.tokens = append(t.tokens, token)
+	}
+	var leading []string
+	category := t.scanner.Scan()
+	for t.trivia && category == scanner.Comment {
+		leading = append(leading, t.scanner.TokenText())
+		category = t.scanner.Scan()
+	}
+	if category == scanner.EOF {
+		if t.err == nil {
+			t.err = types.ErrEOF
+		}
+		if t.indents != nil {
+			t.dedent(t.position(t.scanner.Pos()), 1)
+		}
+		return
+	}
+	p = t.position(t.scanner.Position)
+	if t.indents != nil {
+		t.indent(p)
+	}
+	token := types.NewToken(category, t.scanner.TokenText()).
+		WithPos(types.Pos{
+			Line:   p.Line,
+			Column: p.Column,
+			Loc:    len(t.tokens),
+		}).
+		WithLeading(leading).
+		WithOffsets(p.Offset, t.position(t.scanner.Pos()).Offset)
+	if t.source {
+		token = token.WithSource(t.slice(b.offset, t.position(t.scanner.Pos()).Offset))
+	}
+	t.tokens = append(t.tokens, token)
+}
+
+// Source returns the text of the line numbered line in the input,
+// counting from 1 as positions do and without its line ending, or an
+// empty string if the input has no such line. This is the context an
+// error renderer shows for an error positioned on the line
+func (t *tokeniser) Source(line int) string {
+	if t.lines == nil {
+		start := 0
+		if t.bom && !t.keepBOM {
+			start = len(byteOrderMark)
+		}
+		t.lines = strings.Split(t.slice(start, int(t.reader.Size())), "\n")
+	}
+	if line < 1 || line > len(t.lines) {
+		return ""
+	}
+	return strings.TrimSuffix(t.lines[line-1], "\r")
+}
+
+// SubTokeniser returns a Tokeniser of the tokens at the locations from
+// up to but not including to, as an independent token stream of its own
+// whose locations begin at 0, such as of a region skimmed over by one
+// grammar to be parsed in depth by another. The tokens keep their
+// positions in the input text
+func (t *tokeniser) SubTokeniser(from, to int) types.Tokeniser {
+	return region(t, from, to)
+}
+
+// slice returns the input text between the offsets start and end
+func (t *tokeniser) slice(start, end int) string {
+	b := make([]byte, end-start)
+	if _, err := t.reader.ReadAt(b, int64(start)); err != nil && err != io.EOF {
+		panic(types.ErrInternal)
+	}
+	return string(b)
+}
+
+// record records the batch b as having produced the tokens appended to
+// tokens since it began, if any
+func (t *tokeniser) record(b batch) {
+	if len(t.tokens) == b.loc {
+		return
+	}
+	t.batches = append(t.batches, b)
+	for len(t.batchOf) < len(t.tokens) {
+		t.batchOf = append(t.batchOf, len(t.batches)-1)
+	}
+}
+
+// WithIndentation makes the tokeniser track the indentation of each
+// line, emitting a synthetic Indent token before the first token of a
+// line which is indented further than the last, and a Dedent token for
+// each level of indentation closed by a line which is indented less.
+// Any levels still open at the end of the input are closed by Dedent
+// tokens, so Indent and Dedent tokens always balance
+func (t *tokeniser) WithIndentation() *tokeniser {
+	t.indents = []int{1}
+	return t
+}
+
+// indent appends the synthetic Indent or Dedent tokens which precede a
+// token at the position p
+func (t *tokeniser) indent(p scanner.Position) {
+	if p.Line == t.line {
+		return
+	}
+	t.line = p.Line
+	if p.Column > t.indents[len(t.indents)-1] {
+		t.indents = append(t.indents, p.Column)
+		t.synthetic(types.Indent, p)
+		return
+	}
+	t.dedent(p, p.Column)
+}
+
+// dedent appends a Dedent token positioned at p for each level of
+// indentation greater than column
+func (t *tokeniser) dedent(p scanner.Position, column int) {
+	for len(t.indents) > 1 && column < t.indents[len(t.indents)-1] {
+		t.indents = t.indents[:len(t.indents)-1]
+		t.synthetic(types.Dedent, p)
+	}
+}
+
+// synthetic appends a token of the lexical category c with no text,
+// positioned at p
+func (t *tokeniser) synthetic(c rune, p scanner.Position) {
+	t.tokens = append(t.tokens, types.NewToken(c, "").WithPos(types.Pos{
+		Line:   p.Line,
+		Column: p.Column,
+		Loc:    len(t.tokens),
+	}).WithOffsets(p.Offset, p.Offset))
+}
@@ -0,0 +1,57 @@
+package types
+
+// Deferred is the value of a deferred Term, see Term.Defer, a token
+// whose conversion has been put off until its value is demanded. The
+// conversion runs at most once
+type Deferred struct {
+	// token is the token to convert
+	token Token
+
+	// convert converts the text of token
+	convert func(Token) (any, error)
+
+	// forced indicates that convert has been called,
+	// and v and err hold its results
+	forced bool
+
+	v   any
+	err error
+}
+
+// Force converts the token of d, if it has not been converted already,
+// returning the converted value or the reason the conversion failed
+func (d *Deferred) Force() (any, error) {
+	if !d.forced {
+		d.v, d.err = d.convert(d.token)
+		d.forced = true
+	}
+	return d.v, d.err
+}
+
+// force forces v if it is a Deferred, returning a Halt with the
+// component "conversion" as for an eager Term if the conversion fails
+func force(v any) (any, Result) {
+	d, ok := v.(*Deferred)
+	if !ok {
+		return v, nil
+	}
+	v, err := d.Force()
+	if err != nil {
+		return nil, NewHalt("conversion", err, d.token.pos)
+	}
+	return v, nil
+}
+
+// Force returns r with its value forced if it is a Deferred, or a Halt
+// if the conversion fails. Only the value of r itself is forced, not
+// any Deferred values nested within it
+func Force(r Result) Result {
+	if _, ok := r.(Succeeded); !ok {
+		return r
+	}
+	v, halt := force(r.Value())
+	if halt != nil {
+		return halt
+	}
+	return r.WithValue(v)
+}
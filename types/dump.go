@@ -0,0 +1,121 @@
+package types
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"text/scanner"
+)
+
+// dumper collects the rules of a grammar as they are described by Dump,
+// rules holds the named parsers found so far in order, and seen the
+// names already described
+type dumper struct {
+	rules []*M
+	seen  map[*M]bool
+}
+
+// Dump returns a readable description of the grammar of m, one rule per
+// line for m and each named parser reachable from it, such as:
+//
+//	expr := integer | subexpr
+//	subexpr := "(" <lazy> "+" <lazy> ")"
+//
+// Named parsers are described by their name wherever they are used.
+// Continuations which choose their parser at parse time are described as
+// <lazy>, and parsers other than chains and terms by their name or as
+// <func>. An unnamed m is described as the rule start
+func (m *M) Dump() string {
+	d := dumper{seen: map[*M]bool{}}
+	d.rule(m)
+	var lines []string
+	for i := 0; i < len(d.rules); i++ {
+		r := d.rules[i]
+		name := r.name
+		if name == "" {
+			name = "start"
+		}
+		lines = append(lines, name+" := "+d.body(r))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// rule adds m to the rules to describe, unless it is already
+func (d *dumper) rule(m *M) {
+	if !d.seen[m] {
+		d.seen[m] = true
+		d.rules = append(d.rules, m)
+	}
+}
+
+// body describes the continuations of m
+func (d *dumper) body(m *M) string {
+	var parts []string
+	for i := range m.lazies {
+		var p Parser
+		if i < len(m.shapes) {
+			p = m.shapes[i]
+		}
+		if s := d.describe(p); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	if m.alternation {
+		return strings.Join(parts, " | ")
+	}
+	return strings.Join(parts, " ")
+}
+
+// describe describes the parser p where it is used
+func (d *dumper) describe(p Parser) string {
+	switch p := p.(type) {
+	case nil:
+		return "<lazy>"
+	case Empty:
+		return ""
+	case *M:
+		if p.name != "" {
+			d.rule(p)
+			return p.name
+		}
+		if len(p.lazies) == 1 {
+			return d.body(p)
+		}
+		return "(" + d.body(p) + ")"
+	case Term:
+		return p.describe()
+	case Func:
+		return "<func>"
+	}
+	if p.Name() != "" {
+		return p.Name()
+	}
+	return "<func>"
+}
+
+// describe describes the tokens t matches
+func (t Term) describe() string {
+	switch {
+	case t.oneOf != nil:
+		words := make([]string, 0, len(t.oneOf))
+		for s := range t.oneOf {
+			words = append(words, strconv.Quote(s))
+		}
+		sort.Strings(words)
+		return "(" + strings.Join(words, " | ") + ")"
+	case t.exactMatch != "":
+		return strconv.Quote(t.exactMatch)
+	case t.name == "text":
+		return scanner.TokenString(t.category)
+	}
+	return t.name
+}
+
+// matched describes the token matched by t, the text of the token only
+// if t does not match it exactly
+func (t Term) matched(token Token) string {
+	if t.name == "text" || t.exactMatch != "" || t.oneOf != nil {
+		return t.describe()
+	}
+	return t.describe() + " " + token.match
+}
@@ -21,4 +21,8 @@ var (
 	// ErrEOF indicates that the tokeniser reached the
 	// end of the input
 	ErrEOF = errors.New("end of file")
+
+	// ErrAmbiguous indicates that a strict parser
+	// finished at more than one location
+	ErrAmbiguous = errors.New("ambiguous parse")
 )
@@ -0,0 +1,75 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// explainer is a Tokeniser which collects the narrative of a call to
+// Explain, each step of the parse appends a line to steps
+type explainer struct {
+	Tokeniser
+
+	steps *[]string
+}
+
+// Unwrap returns the Tokeniser the narrative is collected from
+func (e explainer) Unwrap() Tokeniser {
+	return e.Tokeniser
+}
+
+// explainerOf returns the explainer t is or reads through, if any
+func explainerOf(t Tokeniser) (explainer, bool) {
+	for {
+		switch u := t.(type) {
+		case explainer:
+			return u, true
+		case Wrapper:
+			t = u.Unwrap()
+		default:
+			return explainer{}, false
+		}
+	}
+}
+
+// Explain parses t like Parse, regardless of whether the parse succeeds,
+// and returns a step by step narrative of the attempt, one line per
+// step, e.g.
+//
+//	at 1:1 tried expr
+//	at 1:1 expected integer
+//	at 1:1 tried subexpr
+//	at 1:1 matched "("
+//
+// Every named chain tried and its outcome, and every token matched or
+// not by a Term appears in the order the parse reached them
+func (m *M) Explain(t Tokeniser) string {
+	var steps []string
+	m.Parse(explainer{t, &steps})
+	return strings.Join(steps, "\n")
+}
+
+// explain appends the step described by format and args to the
+// narrative of t, positioned at the location loc, if t is explaining
+func explain(t Tokeniser, loc int, format string, args ...any) {
+	e, ok := explainerOf(t)
+	if !ok {
+		return
+	}
+	at := "at end"
+	if p := posAt(t, loc); p.Line > 0 {
+		at = "at " + p.String()
+	}
+	*e.steps = append(*e.steps, at+" "+fmt.Sprintf(format, args...))
+}
+
+// outcome describes the result r as a step of a narrative
+func outcome(r Result) string {
+	switch r.(type) {
+	case Succeeded:
+		return "matched"
+	case Halt:
+		return "halted"
+	}
+	return "failed"
+}
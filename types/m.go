@@ -1,5 +1,11 @@
 package types
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 // lazy represents a continution which takes a Result r; the result of
 // the "previous" parse in a chain. This can be used to delay the choice
 // of the the "next" parser until parse time, and is useful for defining
@@ -12,8 +18,16 @@ func NewLazy(p Parser) lazy {
 	}
 }
 
+// Wrap returns a continuation which applies f to the value of the
+// previous result, forcing it first if it is Deferred
 func Wrap(f func(any) any) lazy {
 	return func(r any) Parser {
+		r, halt := force(r)
+		if halt != nil {
+			return Func(func(Tokeniser) Result {
+				return halt
+			})
+		}
 		return NewEmpty(f(r))
 	}
 }
@@ -32,6 +46,23 @@ type M struct {
 	// error messages
 	name string
 
+	// follow is the optional FOLLOW set of the parser,
+	// the lexical categories of the tokens which may
+	// follow it, used to resynchronise after errors
+	follow []rune
+
+	// strict indicates that the parser halts rather
+	// than finishing at more than one location
+	strict bool
+
+	// barrier indicates that a cut within the parser
+	// stops at it, becoming an ordinary failure
+	barrier bool
+
+	// alternation indicates that the continuations of
+	// the parser are alternatives, used by Dump
+	alternation bool
+
 	// folder controls how continuation are chanied
 	// together, it takes the previous result and next
 	// continuation and combines them to create the next
@@ -43,11 +74,26 @@ type M struct {
 	// previous continuation
 	result Result
 
+	// start is the location in the token stream at
+	// which the previous continuation began parsing
+	start int
+
+	// continuation indicates that M is the rest of
+	// another M being parsed, rather than a rule in its
+	// own right
+	continuation bool
+
 	// lazies is the next continuation result is the
 	// result of invoking the previous continuation
 	// right is the next continuation result is the
 	// result of invoking the
 	lazies []lazy
+
+	// shapes holds the parser each continuation always
+	// returns, if known in advance, for Dump. A nil shape
+	// is a continuation which chooses its parser at parse
+	// time
+	shapes []Parser
 }
 
 func NewM(f func(*M, Tokeniser) Result) *M {
@@ -82,6 +128,66 @@ func (m *M) Name() string {
 	return m.name
 }
 
+// Follow sets the FOLLOW set of M to the lexical categories given by
+// categories, that is, the categories of the tokens which may follow
+// the rule M represents. Error recovery uses the FOLLOW set to decide
+// where to resynchronise after M fails
+func (m *M) Follow(categories ...rune) *M {
+	m.follow = categories
+	return m
+}
+
+// Strict puts M in strict mode, in which a parse by M, or by any M that
+// M applies, that would finish at more than one location halts instead,
+// with a Halt whose component is "ambiguity" listing the conflicting
+// locations. An LL(finite) grammar should never be ambiguous, strict
+// mode makes latent ambiguity in a grammar an immediate failure rather
+// than silently merging results
+func (m *M) Strict() *M {
+	m.strict = true
+	return m
+}
+
+// Barrier makes M a barrier to cuts, see NewCut, a cut within M fails M
+// without halting parsers beyond it. Alternations are barriers, so that
+// a cut commits to one alternative without stopping any enclosing
+// alternation from trying others
+func (m *M) Barrier() *M {
+	m.barrier = true
+	return m
+}
+
+// Alternation marks the continuations of M as alternatives of one
+// another, for Dump, and makes M a barrier to cuts, see Barrier
+func (m *M) Alternation() *M {
+	m.alternation = true
+	return m.Barrier()
+}
+
+// FollowSet returns the FOLLOW set of M
+func (m *M) FollowSet() []rune {
+	return m.follow
+}
+
+// withStrict sets the strict mode of M to strict
+func (m *M) withStrict(strict bool) *M {
+	m.strict = strict
+	return m
+}
+
+// withBarrier sets whether M is a barrier to cuts to barrier
+func (m *M) withBarrier(barrier bool) *M {
+	m.barrier = barrier
+	return m
+}
+
+// Start returns the location in the token stream at which the previous
+// continuation began parsing, folders which try continuations as
+// alternatives seek back to Start before invoking the next
+func (m *M) Start() int {
+	return m.start
+}
+
 // Result returns the result of invoking the previous continuation is
 // the result of invoking the previous continuation
 func (m *M) Result() Result {
@@ -98,9 +204,9 @@ func (m *M) Result() Result {
 // ...
 func (m *M) Passthrough(p Parser) *M {
 	n := NewM(m.folder).Chain(p)
-	return m.Lazy(func(v any) Parser {
-		return n.Return(func(any) any {
-			return v
+	return m.extend([]Parser{p}, func(v any) Parser {
+		return n.Lazy(func(any) Parser {
+			return NewEmpty(v)
 		})
 	})
 }
@@ -145,6 +251,59 @@ func (m *M) String() *M {
 	return m.Passthrough(String())
 }
 
+// Tap returns a chainable parser which parses like m but calls f with
+// the value of the result whenever m succeeds, returning the result
+// unchanged. This is useful for observing values while debugging
+func (m *M) Tap(f func(v any)) *M {
+	return m.tap(func(r Result) {
+		if _, ok := r.(Succeeded); ok {
+			f(r.Value())
+		}
+	})
+}
+
+// TapErrors returns a chainable parser which parses like m but calls f
+// with the parse errors of the result whenever m fails, returning the
+// failure unchanged. This is useful for logging or counting which rules
+// of a grammar fail
+func (m *M) TapErrors(f func([]ParseError)) *M {
+	return m.tap(func(r Result) {
+		if _, ok := r.(Failed); ok {
+			f(r.Errors())
+		}
+	})
+}
+
+// MapFailed returns a chainable parser which parses like m but, whenever
+// m fails, returns the result of f called with the Tokeniser, the
+// location at which m began and the failure in its place. This is
+// useful for adding context to the errors of a rule. Dump describes the
+// parser as it does m
+func (m *M) MapFailed(f func(t Tokeniser, start int, r Result) Result) *M {
+	p := Func(func(t Tokeniser) Result {
+		start := t.Loc()
+		r := m.Parse(t)
+		if _, ok := r.(Failed); ok {
+			return f(t, start, r)
+		}
+		return r
+	})
+	return NewM(m.folder).extend([]Parser{m}, NewLazy(p))
+}
+
+// tap returns a chainable parser which parses like m but calls f with
+// every result of m
+func (m *M) tap(f func(Result)) *M {
+	return NewM(m.folder).
+		WithName(m.name).
+		Follow(m.follow...).
+		Chain(Func(func(t Tokeniser) Result {
+			r := m.Parse(t)
+			f(r)
+			return r
+		}))
+}
+
 // Lazy chains a continuation which chooses the "next" Parser to
 // continue the execution with on to the end of m, this will be invoked
 // with the result of the "previous" continuation. How parsers are
@@ -167,10 +326,27 @@ func (m *M) String() *M {
 //		})
 
 func (m *M) Lazy(lazies ...lazy) *M {
-	return NewM(m.folder).
+	return m.extend(make([]Parser, len(lazies)), lazies...)
+}
+
+// extend returns a copy of m with the continuations lazies chained on
+// to the end, whose parsers are known in advance to be shapes
+func (m *M) extend(shapes []Parser, lazies ...lazy) *M {
+	n := NewM(m.folder).
+		WithName(m.name).
+		Follow(m.follow...).
+		withStrict(m.strict).
+		withBarrier(m.barrier).
 		WithResult(m.result).
 		WithLazies(m.lazies...).
 		WithLazies(lazies...)
+	n.alternation = m.alternation
+	n.shapes = append(n.shapes, m.shapes...)
+	for len(n.shapes) < len(m.lazies) {
+		n.shapes = append(n.shapes, nil)
+	}
+	n.shapes = append(n.shapes, shapes...)
+	return n
 }
 
 // Chain chains a parser on to the end of m, this is just shorthand for
@@ -185,7 +361,7 @@ func (m *M) Lazy(lazies ...lazy) *M {
 //
 //	a.Chain(b)
 func (m *M) Chain(p Parser) *M {
-	return m.Lazy(NewLazy(p))
+	return m.extend([]Parser{p}, NewLazy(p))
 }
 
 // Return chains a parser on to the end of m, this is just shorthand for
@@ -203,28 +379,131 @@ func (m *M) Chain(p Parser) *M {
 //
 //	a.Return(f)
 func (m *M) Return(f func(any) any) *M {
-	return m.Lazy(Wrap(f))
+	return m.extend([]Parser{Empty{}}, Wrap(f))
+}
+
+// parsing is a Tokeniser which marks a parse as under way, so that the
+// outermost M of a parse can be told apart from those it applies
+type parsing struct {
+	Tokeniser
+}
+
+// Unwrap returns the Tokeniser being parsed
+func (p parsing) Unwrap() Tokeniser {
+	return p.Tokeniser
+}
+
+// underway reports whether t is or reads through a Tokeniser marked as
+// being parsed by an M
+func underway(t Tokeniser) bool {
+	for {
+		switch u := t.(type) {
+		case parsing:
+			return true
+		case Wrapper:
+			t = u.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+// strictly is a Tokeniser which marks a parse as being in strict mode,
+// so that every M applied within a Strict M is strict too
+type strictly struct {
+	Tokeniser
+}
+
+// Unwrap returns the Tokeniser being parsed strictly
+func (s strictly) Unwrap() Tokeniser {
+	return s.Tokeniser
+}
+
+// isStrict reports whether t is or reads through a Tokeniser marked as
+// being parsed in strict mode
+func isStrict(t Tokeniser) bool {
+	for {
+		switch u := t.(type) {
+		case strictly:
+			return true
+		case Wrapper:
+			t = u.Unwrap()
+		default:
+			return false
+		}
+	}
 }
 
 // Parse invokes a folder function to combine continuations in the
 // chain. A folder is called with a continuation b and the with the
 // result obtained from applying the parser returned by continuation a
 // to the token stream. The result returned by the folder function over
-// the continuation chain is the parse result.
+// the continuation chain is the parse result. The outermost M of a
+// parse is a barrier to cuts, see Barrier, so that a cut never escapes
+// the parse as a Halt
 func (m *M) Parse(t Tokeniser) (r Result) {
+	if !underway(t) {
+		t = parsing{t}
+		defer func() {
+			r = Uncut(r)
+		}()
+	}
+	strict := isStrict(t)
+	if m.strict && !strict {
+		t, strict = strictly{t}, true
+	}
+	start := t.Loc()
+	if m.name != "" && !m.continuation {
+		explain(t, start, "tried %s", m.name)
+		defer func() {
+			explain(t, start, "%s %s", m.name, outcome(r))
+		}()
+	}
+	r = m.parse(t)
+	if strict && r != nil && len(r.Locs()) > 1 {
+		locs := make([]int, 0, len(r.Locs()))
+		for loc := range r.Locs() {
+			locs = append(locs, loc)
+		}
+		sort.Ints(locs)
+		ss := make([]string, len(locs))
+		for i, loc := range locs {
+			ss[i] = fmt.Sprint(loc)
+		}
+		name := m.name
+		if name == "" {
+			name = "parser"
+		}
+		r = NewHalt("ambiguity", fmt.Errorf(
+			"%w: %s finishes at locations %s",
+			ErrAmbiguous, name, strings.Join(ss, ", "),
+		), posAt(t, start))
+	}
+	if m.barrier {
+		r = Uncut(r)
+	}
+	return
+}
+
+// parse applies the continuations of m, see Parse
+func (m *M) parse(t Tokeniser) (r Result) {
 	if len(m.lazies) == 0 {
 		return
 	}
+	start := t.Loc()
 	lazy := m.lazies[0]
-	r = lazy(m.result.value()).Parse(t)
+	r = lazy(m.result.Value()).Parse(t)
 
 	if len(m.lazies) == 1 {
 		return
 	}
 	lazies := m.lazies[1:]
-	r = m.folder(NewM(m.folder).
+	next := NewM(m.folder).
 		WithName(m.name).
 		WithResult(r).
-		WithLazies(lazies...), t)
+		WithLazies(lazies...)
+	next.start = start
+	next.continuation = true
+	r = m.folder(next, t)
 	return
 }
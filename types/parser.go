@@ -1,8 +1,13 @@
 package types
 
 import (
+	"errors"
+	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"text/scanner"
+	"unicode/utf8"
 )
 
 // Parser is the basic interface for a parser. A Parser is simply an
@@ -53,10 +58,71 @@ type Tokeniser interface {
 
 	// Peek returns the the Token at the current
 	// location of the tokeniser without actually
-	// advancing the location
+	// advancing the location. Peek reports false only
+	// when there is no token at the location, which is
+	// the end of the input unless Err reports an error
+	// other than ErrEOF, in which case scanning failed
 	Peek() (Token, bool)
+
+	// Env returns the user defined environment of the
+	// parse, the interpretation of which is up to the
+	// parsers which use it
+	Env() any
+
+	// Err returns the first error encountered scanning
+	// the input, otherwise ErrEOF once the end of the
+	// input was reached, or nil if there was neither
+	Err() error
+
+	// PushWhitespace makes the Tokeniser skip the set
+	// of characters ws, as for scanner.Scanner, until
+	// the matching call to PopWhitespace
+	PushWhitespace(ws uint64)
+
+	// PopWhitespace restores the set of characters
+	// skipped to the set in effect before the last
+	// call to PushWhitespace
+	PopWhitespace()
+
+	// PushLexer makes the Tokeniser scan the input by
+	// the rules of lex, until the matching call to
+	// PopLexer
+	PushLexer(lex *Lexer)
+
+	// PopLexer restores the rules by which the input is
+	// scanned to those in effect before the last call
+	// to PushLexer
+	PopLexer()
 }
 
+// Wrapper is a Tokeniser which reads through another, such as one
+// restricting it to a window of its tokens. Unwrap returns the Tokeniser
+// read through, so that the Tokenisers of Explain and ParseStream are
+// found beneath any wrappers a parse has applied to them
+type Wrapper interface {
+	Tokeniser
+	Unwrap() Tokeniser
+}
+
+// Lexer is a set of rules by which a Tokeniser scans the input into
+// tokens, in place of its own, for input such as a regular expression
+// literal embedded in another language which has to be scanned by rules
+// of its own. Mode, Whitespace and IsIdentRune are as for the fields of
+// scanner.Scanner of the same names
+type Lexer struct {
+	Mode        uint
+	Whitespace  uint64
+	IsIdentRune func(ch rune, i int) bool
+}
+
+// Indent and Dedent are the lexical categories of the synthetic tokens
+// emitted by a Tokeniser which tracks indentation, when the indentation
+// of a line increases or decreases respectively. Neither has any text
+const (
+	Indent rune = -(iota + 16)
+	Dedent
+)
+
 // Token represents a lexical token emitted by a Tokeniser. A tokeniser
 // has an associated lexical category which defines its "class" or
 // "meaning"; the class of its match string
@@ -68,10 +134,173 @@ type Token struct {
 	// match is the actual token value matched from the
 	// tokeniser input text
 	match string
+
+	// pos is the position in the tokeniser input text
+	// at which the token begins
+	pos Pos
+
+	// leading is the trivia, e.g. comments, which
+	// preceded the token in the input text
+	leading []string
+
+	// source is the exact input text from the end of
+	// the previous token up to the end of this one, if
+	// kept by the tokeniser
+	source string
+
+	// offset and end are the byte offsets in the input
+	// text at which the token begins and ends, if
+	// recorded by the tokeniser
+	offset, end int
 }
 
 func NewToken(t rune, match string) Token {
-	return Token{t, match}
+	return Token{category: t, match: match}
+}
+
+// Category returns the lexical category of the token
+func (t Token) Category() rune {
+	return t.category
+}
+
+// Match returns the text of the token matched from the input text
+func (t Token) Match() string {
+	return t.match
+}
+
+// WithPos returns a Token positioned at p in the tokeniser input text
+func (t Token) WithPos(p Pos) Token {
+	t.pos = p
+	return t
+}
+
+// Pos returns the position in the tokeniser input text at which the
+// token begins
+func (t Token) Pos() Pos {
+	return t.pos
+}
+
+// End returns the position in the tokeniser input text immediately
+// after the token, at the location of the next token
+func (t Token) End() Pos {
+	end := Pos{Line: t.pos.Line, Column: t.pos.Column, Loc: t.pos.Loc + 1}
+	if i := strings.LastIndexByte(t.match, '\n'); i >= 0 {
+		end.Line += strings.Count(t.match, "\n")
+		end.Column = utf8.RuneCountInString(t.match[i+1:]) + 1
+	} else {
+		end.Column += utf8.RuneCountInString(t.match)
+	}
+	return end
+}
+
+// WithLeading returns a Token preceded by the trivia ss in the tokeniser
+// input text
+func (t Token) WithLeading(ss []string) Token {
+	t.leading = ss
+	return t
+}
+
+// WithSource returns a Token whose exact input text, including the
+// whitespace and comments before it, is s
+func (t Token) WithSource(s string) Token {
+	t.source = s
+	return t
+}
+
+// Source returns the exact input text from the end of the previous token
+// up to the end of this one, whitespace and comments included, for a
+// tokeniser which keeps it. Concatenating the source of each token in
+// turn reproduces the input text
+func (t Token) Source() string {
+	return t.source
+}
+
+// WithOffsets returns a Token which begins at the byte offset start in
+// the tokeniser input text and ends at the byte offset end
+func (t Token) WithOffsets(start, end int) Token {
+	t.offset, t.end = start, end
+	return t
+}
+
+// Offsets returns the byte offsets in the tokeniser input text at which
+// the token begins and ends, for a tokeniser which records them. The
+// offsets of adjacent tokens coincide, the end of one being the start of
+// the next, only if nothing separates them in the input
+func (t Token) Offsets() (start, end int) {
+	return t.offset, t.end
+}
+
+// Leading returns the trivia, such as comments, which preceded the token
+// in the tokeniser input text, in the order they appeared
+func (t Token) Leading() []string {
+	return t.leading
+}
+
+// Pos represents a position in the tokeniser input text. Line and
+// Column are counted from 1 as for scanner.Position, Loc is the location
+// of the token in the token stream
+type Pos struct {
+	Line   int
+	Column int
+	Loc    int
+}
+
+// posAt returns the position of the token at the location loc of t, or
+// just loc if loc is the end of the input
+func posAt(t Tokeniser, loc int) Pos {
+	cur := t.Loc()
+	defer t.Seek(cur)
+	t.Seek(loc)
+	if token, ok := t.Peek(); ok {
+		return token.pos
+	}
+	return Pos{Loc: loc}
+}
+
+// endOf returns the position just after the token before the current
+// location of t, the end of the input when the input ends there,
+// leaving t where it was
+func endOf(t Tokeniser) Pos {
+	loc := t.Loc()
+	if loc == 0 {
+		return Pos{Line: 1, Column: 1}
+	}
+	defer t.Seek(loc)
+	t.Seek(loc - 1)
+	token, ok := t.Peek()
+	if !ok {
+		return Pos{Loc: loc}
+	}
+	pos := token.pos
+	for _, c := range token.match {
+		if c == '\n' {
+			pos.Line, pos.Column = pos.Line+1, 1
+		} else {
+			pos.Column++
+		}
+	}
+	pos.Loc = loc
+	return pos
+}
+
+// PeekN returns up to n tokens from the current location of t onwards,
+// fewer if the input ends first, leaving t where it was
+func PeekN(t Tokeniser, n int) []Token {
+	cur := t.Loc()
+	defer t.Seek(cur)
+	tokens := make([]Token, 0, n)
+	for ; len(tokens) < n; t.Inc() {
+		token, ok := t.Peek()
+		if !ok {
+			break
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 // Empty is is the most primitive parser. It only recognises the Empty
@@ -95,6 +324,369 @@ func (e Empty) Parse(s Tokeniser) Result {
 	return NewSucceeded(e.value, s.Loc())
 }
 
+// Func is an adapter which allows an ordinary function to be used as a
+// Parser, the function is called with the Tokeniser and returns the
+// parse Result directly. A Func has no name
+type Func func(Tokeniser) Result
+
+func (Func) Name() string {
+	return ""
+}
+
+// Parse calls f with the Tokeniser t
+func (f Func) Parse(t Tokeniser) Result {
+	return f(t)
+}
+
+// Position returns a Parser which consumes nothing and always succeeds
+// with the Pos of the next token as its value, that is, the position at
+// which whatever is parsed next begins. At the end of the input, only
+// the Loc of the returned Pos is set
+func Position() Func {
+	return func(t Tokeniser) Result {
+		token, ok := t.Peek()
+		if !ok {
+			return NewSucceeded(Pos{Loc: t.Loc()}, t.Loc())
+		}
+		return NewSucceeded(token.pos, t.Loc())
+	}
+}
+
+// JSONPointer returns a Parser which parses a JSON pointer as defined by
+// RFC 6901, e.g. /a/b~1c, returning the unescaped reference tokens as a
+// []string. Since the reference tokens of a pointer are raw text rather
+// than go tokens, JSONPointer requires a Tokeniser which emits the input
+// a character at a time and consumes every remaining character
+func JSONPointer() Func {
+	return func(t Tokeniser) Result {
+		refs := []string{}
+		for token, ok := t.Peek(); ok; token, ok = t.Peek() {
+			if token.category != '/' {
+				return NewFailed("/")
+			}
+			t.Inc()
+
+			var ref strings.Builder
+			for token, ok = t.Peek(); ok && token.category != '/'; token, ok = t.Peek() {
+				t.Inc()
+				if token.category != '~' {
+					ref.WriteString(token.match)
+					continue
+				}
+				switch token, _ = t.Peek(); token.match {
+				case "0":
+					ref.WriteRune('~')
+				case "1":
+					ref.WriteRune('/')
+				default:
+					return NewFailed("~0 or ~1")
+				}
+				t.Inc()
+			}
+			refs = append(refs, ref.String())
+		}
+		return NewSucceeded(refs, t.Loc())
+	}
+}
+
+// LocaleNumber returns a Parser which parses a number written with the
+// digit group separator groupSep and the decimal separator decimalSep,
+// e.g. 1,234.56 or 1.234,56, returning the value as a float64. As with
+// JSONPointer, LocaleNumber requires a Tokeniser which emits the input a
+// character at a time. A group separator which is not followed by a
+// digit is not consumed, so that it may separate numbers in a list
+func LocaleNumber(groupSep, decimalSep rune) Func {
+	isDigit := func(token Token) bool {
+		return len(token.match) == 1 && token.match[0] >= '0' && token.match[0] <= '9'
+	}
+	return func(t Tokeniser) Result {
+		first, ok := t.Peek()
+		if !ok || !isDigit(first) {
+			return NewFailed("number")
+		}
+
+		var number strings.Builder
+		decimal := false
+		for token, ok := t.Peek(); ok; token, ok = t.Peek() {
+			switch {
+			case isDigit(token):
+				number.WriteString(token.match)
+			case token.category == groupSep && !decimal:
+				t.Inc()
+				next, ok := t.Peek()
+				t.Dec()
+				if !ok || !isDigit(next) {
+					return convertNumber(number.String(), first.pos, t.Loc())
+				}
+			case token.category == decimalSep && !decimal:
+				decimal = true
+				number.WriteRune('.')
+			default:
+				return convertNumber(number.String(), first.pos, t.Loc())
+			}
+			t.Inc()
+		}
+		return convertNumber(number.String(), first.pos, t.Loc())
+	}
+}
+
+// convertNumber converts the normalised number text s beginning at pos
+// and finishing at the location loc into a float64
+func convertNumber(s string, pos Pos, loc int) Result {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return NewHalt("conversion", err, pos)
+	}
+	return NewSucceeded(v, loc)
+}
+
+// BalancedAware returns a Parser which parses a balanced region of
+// tokens beginning with open and finishing with the matching close,
+// returning the tokens between the two as a []Token. Brackets within
+// string, character and raw string literals don't count toward the
+// nesting depth. With go tokens a literal is always a single token, so
+// this only matters for a Tokeniser which emits the input a character
+// at a time, where BalancedAware tracks the quotes and escapes itself
+func BalancedAware(open, close rune) Func {
+	return func(t Tokeniser) Result {
+		token, ok := t.Peek()
+		if !ok || token.category != open {
+			return NewFailed(string(open))
+		}
+		t.Inc()
+
+		var (
+			region  []Token
+			depth   = 1
+			quote   rune
+			escaped bool
+		)
+		for {
+			token, ok := t.Peek()
+			if !ok {
+				return NewFailed(string(close))
+			}
+			t.Inc()
+
+			switch c := token.category; {
+			case escaped:
+				escaped = false
+			case quote != 0 && c == '\\' && quote != '`':
+				escaped = true
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case c == '"' || c == '\'' || c == '`':
+				quote = c
+			case c == open:
+				depth++
+			case c == close:
+				if depth--; depth == 0 {
+					return NewSucceeded(region, t.Loc())
+				}
+			}
+			region = append(region, token)
+		}
+	}
+}
+
+// Any2 returns a Parser which matches any one token, of any lexical
+// category, returning the Token itself, so that generic tooling can
+// inspect its Category and Match. Any2 only fails at the end of the
+// input
+func Any2() Func {
+	return func(t Tokeniser) Result {
+		token, ok := t.Peek()
+		if !ok {
+			return NewFailed("any token")
+		}
+		t.Inc()
+		return NewSucceeded(token, t.Loc())
+	}
+}
+
+// Chars returns a Parser which parses a run of one or more characters
+// for which pred returns true, returning the run as a string. Chars
+// requires a Tokeniser which emits the input a character at a time
+func Chars(pred func(rune) bool) Func {
+	return func(t Tokeniser) Result {
+		var run strings.Builder
+		for token, ok := t.Peek(); ok && pred(token.category); token, ok = t.Peek() {
+			run.WriteString(token.match)
+			t.Inc()
+		}
+		if run.Len() == 0 {
+			return NewFailed("characters")
+		}
+		return NewSucceeded(run.String(), t.Loc())
+	}
+}
+
+// Heredoc returns a Parser which parses a heredoc, the opening marker
+// parsed by startMarker followed by a newline and then every following
+// line verbatim until a line which is exactly the marker. The value is
+// the body between the markers, excluding the newline before the
+// closing marker, and the newline after the closing marker is not
+// consumed. Heredoc requires a Tokeniser which emits the input a
+// character at a time, including newlines
+func Heredoc(startMarker Parser) Func {
+	return func(t Tokeniser) Result {
+		r := startMarker.Parse(t)
+		if _, ok := r.(Succeeded); !ok {
+			return r
+		}
+		for loc := range r.Locs() {
+			t.Seek(loc)
+			break
+		}
+		marker := fmt.Sprint(r.Value())
+		if token, ok := t.Peek(); !ok || token.category != '\n' {
+			return NewFailed("newline")
+		}
+		t.Inc()
+
+		var (
+			lines []string
+			line  strings.Builder
+		)
+		for {
+			token, ok := t.Peek()
+			if !ok || token.category == '\n' {
+				if line.String() == marker {
+					return NewSucceeded(strings.Join(lines, "\n"), t.Loc())
+				}
+				if !ok {
+					return NewFailed(marker)
+				}
+				lines = append(lines, line.String())
+				line.Reset()
+			} else {
+				line.WriteString(token.match)
+			}
+			t.Inc()
+		}
+	}
+}
+
+// NestedComment returns a Parser which parses a comment beginning with
+// open and finishing with the matching close, in which comments may be
+// nested, as in Rust or Haskell, returning the text of the whole comment
+// including its delimiters. A comment left open at the end of the input
+// fails with an error positioned at its beginning. NestedComment
+// requires a Tokeniser which emits the input a character at a time
+func NestedComment(open, close string) Func {
+	return func(t Tokeniser) Result {
+		var text strings.Builder
+		skip := func(delim string) bool {
+			if !skipText(t, delim) {
+				return false
+			}
+			text.WriteString(delim)
+			return true
+		}
+
+		pos := posAt(t, t.Loc())
+		if !skip(open) {
+			return NewFailed(open)
+		}
+		for depth := 1; depth > 0; {
+			switch {
+			case skip(open):
+				depth++
+			case skip(close):
+				depth--
+			default:
+				token, ok := t.Peek()
+				if !ok {
+					return NewFailedAt("unterminated comment", pos)
+				}
+				text.WriteString(token.match)
+				t.Inc()
+			}
+		}
+		return NewSucceeded(text.String(), t.Loc())
+	}
+}
+
+// skipText moves t past the characters of s if they are the next tokens
+// of t, reporting whether they were, for a Tokeniser which emits the
+// input a character at a time
+func skipText(t Tokeniser, s string) bool {
+	var next strings.Builder
+	for _, token := range PeekN(t, utf8.RuneCountInString(s)) {
+		next.WriteString(token.match)
+	}
+	if next.String() != s {
+		return false
+	}
+	t.Seek(t.Loc() + utf8.RuneCountInString(s))
+	return true
+}
+
+// MultilineString returns a Parser which parses a string beginning with
+// open and finishing with close, such as the triple quoted strings of
+// TOML, which unlike String may contain newlines. Escape sequences are
+// processed as for a Go string, and an escaped character never closes
+// the string. A string left open at the end of the input fails with an
+// error positioned at its beginning, an invalid escape sequence halts.
+// MultilineString requires a Tokeniser which emits the input a character
+// at a time, including newlines
+func MultilineString(open, close string) Func {
+	return func(t Tokeniser) Result {
+		pos := posAt(t, t.Loc())
+		if !skipText(t, open) {
+			return NewFailed(open)
+		}
+		var raw strings.Builder
+		for !skipText(t, close) {
+			token, ok := t.Peek()
+			if !ok {
+				return NewFailedAt("unterminated string", pos)
+			}
+			raw.WriteString(token.match)
+			t.Inc()
+			if token.category != '\\' {
+				continue
+			}
+			if token, ok = t.Peek(); ok {
+				raw.WriteString(token.match)
+				t.Inc()
+			}
+		}
+		s, err := unescape(raw.String())
+		if err != nil {
+			return NewHalt("conversion", err, pos)
+		}
+		return NewSucceeded(s, t.Loc())
+	}
+}
+
+// unescape returns s with its escape sequences replaced by the
+// characters they stand for, as in a Go string
+func unescape(s string) (string, error) {
+	var b strings.Builder
+	for len(s) > 0 {
+		if s[0] != '\\' {
+			c, n := utf8.DecodeRuneInString(s)
+			b.WriteRune(c)
+			s = s[n:]
+			continue
+		}
+		c, multibyte, tail, err := strconv.UnquoteChar(s, '"')
+		if err != nil {
+			return "", fmt.Errorf("invalid escape sequence in %q", s)
+		}
+		if multibyte {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte(byte(c))
+		}
+		s = tail
+	}
+	return b.String(), nil
+}
+
 // converter or converters are, functions called to convert the token
 // text. A converter take the token text as input and returns and any
 // and possibly and error indicating that the conversion failed
@@ -118,10 +710,26 @@ type Term struct {
 	// the lexical category
 	exactMatch string
 
+	// fold indicates that exactMatch is compared with the
+	// token text case insensitively
+	fold bool
+
+	// oneOf is an optional set of strings, when non-nil
+	// the token text has to be a member of the set
+	oneOf map[string]None
+
 	// converter is called to convert the literal
 	// token text matched by this parser into the actual
 	// value stored in the Term's parse result
 	converter converter
+
+	// deferred indicates that the converter is not
+	// called until the value is demanded
+	deferred bool
+
+	// validate is an optional check of the converted
+	// value, returning the reason the value is invalid
+	validate func(v any) error
 }
 
 func NewTerm(name string, category rune) Term {
@@ -149,16 +757,76 @@ func Id(s string) Term {
 		WithExactMatch(s)
 }
 
+// IdFold returns a Parser which parsers a go identifier and only
+// succeeds if the parsed token text matches s case insensitively, the
+// value of the parser is always s itself regardless of the case of the
+// token text, so IdFold("select") matches SELECT with the value "select"
+func IdFold(s string) Term {
+	t := Id(s).WithConverter(func(string) (any, error) {
+		return s, nil
+	})
+	t.fold = true
+	return t
+}
+
+// Ident returns a Parser which parsers any go identifier, returning the
+// identifier text
+func Ident() Term {
+	return NewTerm("identifier", scanner.Ident)
+}
+
 // Int returns a Parser which parsers a go decimal literal and returns
 // an returns the corresponding value as an int64 in the parser
-// result
+// result. As in go, the literal may contain underscores separating
+// successive digits, e.g. 1_000
 func Int() Term {
 	return NewTerm("integer", scanner.Int).
 		WithConverter(func(s string) (any, error) {
+			s, err := stripSeparators(s)
+			if err != nil {
+				return nil, err
+			}
 			return strconv.ParseInt(s, 10, 64)
 		})
 }
 
+// IntRange returns a Parser which parses a go decimal literal like Int
+// but fails if its value is outside of the inclusive range [min,max],
+// with an error positioned at the literal
+func IntRange(min, max int64) Term {
+	return Int().WithValidation(func(v any) error {
+		if n := v.(int64); n < min || n > max {
+			return fmt.Errorf("value %d out of range [%d,%d]", n, min, max)
+		}
+		return nil
+	})
+}
+
+// Radix returns a Parser which parsers a go integer literal in any of
+// the notations go allows, hexadecimal 0x, octal 0o or 0, binary 0b and
+// decimal, returning the corresponding value as an int64 in the parser
+// result
+func Radix() Term {
+	return NewTerm("integer", scanner.Int).
+		WithConverter(func(s string) (any, error) {
+			return strconv.ParseInt(s, 0, 64)
+		})
+}
+
+// stripSeparators removes the underscore digit separators from the
+// number literal s, which as in go have to separate successive digits
+func stripSeparators(s string) (string, error) {
+	if !strings.Contains(s, "_") {
+		return s, nil
+	}
+	if strings.HasPrefix(s, "_") ||
+		strings.HasSuffix(s, "_") ||
+		strings.Contains(s, "__") {
+		return "", fmt.Errorf("invalid digit separator in %q", s)
+	}
+	return strings.ReplaceAll(s, "_", ""), nil
+}
+
 // Int returns a Parser which parsers a go floating point literal and
 // returns an returns the corresponding value as an float64 in the
 // parser result
@@ -178,6 +846,21 @@ func String() Term {
 		})
 }
 
+// Comment returns a Parser which parses a comment, returning its text
+// including the comment delimiters. Comments are only emitted as tokens
+// by a Tokeniser which does not skip them
+func Comment() Term {
+	return NewTerm("comment", scanner.Comment)
+}
+
+// Keywords returns a Parser which parses a go identifier and only
+// succeeds if the parsed token text is one of words, returning the
+// matched word. The words are held in a set so matching takes a single
+// lookup regardless of the number of words
+func Keywords(words ...string) Term {
+	return NewTerm("keyword", scanner.Ident).WithExactMatchAny(words...)
+}
+
 // WithExactmatch returns a Term which has to match the exact token
 // text specified by s and will otherwise fail
 func (t Term) WithExactMatch(s string) Term {
@@ -185,6 +868,43 @@ func (t Term) WithExactMatch(s string) Term {
 	return t
 }
 
+// WithExactMatchAny returns a Term which has to match any one of the
+// exact token texts specified by ss and will otherwise fail. The token
+// still has to belong to the lexical category of the Term
+func (t Term) WithExactMatchAny(ss ...string) Term {
+	t.oneOf = make(map[string]None, len(ss))
+	for _, s := range ss {
+		t.oneOf[s] = None{}
+	}
+	return t
+}
+
+// WithCategory returns a copy of t which matches tokens of the lexical
+// category c in place of its own, keeping its name, exact matches and
+// converter
+func (t Term) WithCategory(c rune) Term {
+	t.category = c
+	return t
+}
+
+// WithValidation returns a Term which fails if f returns an error for
+// the converted value of the token, with the message of the error
+// positioned at the token. A Term with validation always converts the
+// token straight away, even if deferred
+func (t Term) WithValidation(f func(v any) error) Term {
+	t.validate = f
+	return t
+}
+
+// Defer returns a Term which puts off converting the token text until
+// the value is demanded, the value of its result is a *Deferred. Return
+// and Force force the conversion, so a token recognised on a path which
+// is later abandoned is never converted and cannot halt the parse
+func (t Term) Defer() Term {
+	t.deferred = true
+	return t
+}
+
 // WithConverter returns a Term which calls the converter c on the token
 // text and stores the returend value instead of the token text itself
 func (t Term) WithConverter(c converter) Term {
@@ -199,20 +919,106 @@ func (t Term) Name() string {
 // Parse takes a Text and returns a LocSet representing the NewTerm
 // returns a Terminal with the name n, which matches a token of the
 // lexical category specified by c.
+//
+// A term which fails on input the tokeniser could not scan halts with
+// the scan error, with the component "scan", rather than failing
 func (t Term) Parse(tokeniser Tokeniser) Result {
 	switch token, ok := tokeniser.Peek(); {
 	case !ok:
-		fallthrough
+		if h := ScanHalt(tokeniser); h != nil {
+			return h
+		}
+		explain(tokeniser, tokeniser.Loc(), "expected %s", t.describe())
+		return NewFailedAtEnd(t.expected(), endOf(tokeniser))
 	case token.category != t.category:
 		fallthrough
-	case t.exactMatch != "" && token.match != t.exactMatch:
-		return NewFailed(t.exactMatch)
+	case t.exactMatch != "" && !t.isExactMatch(token.match):
+		fallthrough
+	case t.oneOf != nil && !t.isOneOf(token.match):
+		if h := ScanHalt(tokeniser); h != nil {
+			return h
+		}
+		explain(tokeniser, tokeniser.Loc(), "expected %s", t.describe())
+		return NewFailed(t.expected())
+	case t.deferred && t.validate == nil:
+		explain(tokeniser, tokeniser.Loc(), "matched %s", t.matched(token))
+		tokeniser.Inc()
+		return NewSucceeded(&Deferred{token: token, convert: t.convert}, tokeniser.Loc())
 	default:
-		v, err := t.converter(token.match)
+		v, err := t.convert(token)
 		if err != nil {
-			panic(err)
+			return NewHalt("conversion", err, token.pos)
+		}
+		if t.validate != nil {
+			if err := t.validate(v); err != nil {
+				return NewFailedAt(err.Error(), token.pos)
+			}
 		}
+		explain(tokeniser, tokeniser.Loc(), "matched %s", t.matched(token))
 		tokeniser.Inc()
 		return NewSucceeded(v, tokeniser.Loc())
 	}
 }
+
+// ScanHalt returns a Halt with the component "scan", positioned at the
+// current location of t, if t encountered an error scanning the input
+// other than reaching its end, and otherwise nil
+func ScanHalt(t Tokeniser) Result {
+	if err := t.Err(); err != nil && !errors.Is(err, ErrEOF) {
+		return NewHalt("scan", err, posAt(t, t.Loc()))
+	}
+	return nil
+}
+
+// expected returns what t expects to match for use in error messages,
+// the exact match if there is one and otherwise the name of t
+func (t Term) expected() string {
+	if t.oneOf != nil {
+		return t.expectedOneOf()
+	}
+	if t.exactMatch != "" {
+		return t.exactMatch
+	}
+	return t.name
+}
+
+// isExactMatch reports whether s matches exactMatch
+func (t Term) isExactMatch(s string) bool {
+	if t.fold {
+		return strings.EqualFold(s, t.exactMatch)
+	}
+	return s == t.exactMatch
+}
+
+// isOneOf reports whether s is a member of the set oneOf
+func (t Term) isOneOf(s string) bool {
+	_, ok := t.oneOf[s]
+	return ok
+}
+
+// expectedOneOf returns a description of the set oneOf in sorted order
+// for use in error messages
+func (t Term) expectedOneOf() string {
+	ss := make([]string, 0, len(t.oneOf))
+	for s := range t.oneOf {
+		ss = append(ss, s)
+	}
+	sort.Strings(ss)
+	return "one of " + strings.Join(ss, ", ")
+}
+
+// convert calls the converter of t on the text of token, a converter
+// which panics is treated as though it returned the recovered value as
+// an error
+func (t Term) convert(token Token) (v any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+	return t.converter(token.match)
+}
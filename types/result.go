@@ -1,5 +1,11 @@
 package types
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 type None struct{}
 
 // locs is a map representing a set of unique locations or indicies into
@@ -30,10 +36,94 @@ type parseError struct {
 	// it encountered an unexpected token sequence, or
 	// one different to the one it was expecting
 	expected string
+
+	// message is an optional description of the error
+	// used in place of expected
+	message string
+
+	// pos is the optional position in the input text at
+	// which the error occurred, its Line is 0 if unset
+	pos Pos
+
+	// atEnd indicates that the error occurred at the end
+	// of the input
+	atEnd bool
+
+	// end is the position just after the last token of
+	// the input, set only when atEnd is
+	end Pos
 }
 
+// ParseError is the exported name of parseError, for use in the
+// signatures of functions which take parse errors
+type ParseError = parseError
+
 func newParseError(s string) parseError {
-	return parseError{s}
+	return parseError{expected: s}
+}
+
+// Error returns the error message for e, prefixed with its position if
+// it has one
+func (e parseError) Error() string {
+	msg := "expected " + e.expected
+	if e.message != "" {
+		msg = e.message
+	}
+	if e.pos.Line > 0 {
+		return e.pos.String() + ": " + msg
+	}
+	return msg
+}
+
+// Pos returns the position in the input text at which the error
+// occurred, if known
+func (e parseError) Pos() Pos {
+	return e.pos
+}
+
+// AtEnd reports whether the error occurred at the end of the input,
+// where the parser expected more
+func (e parseError) AtEnd() bool {
+	return e.atEnd
+}
+
+// End returns the position just after the last token of the input for
+// an error which occurred at the end of the input, see AtEnd
+func (e parseError) End() Pos {
+	return e.end
+}
+
+// Expected returns the name of what the parser was expecting when it
+// failed, or the empty string if the error is described by a message
+// instead
+func (e parseError) Expected() string {
+	if e.message != "" {
+		return ""
+	}
+	return e.expected
+}
+
+// ResultKind is the kind of a Result, one of SucceededKind, FailedKind
+// or HaltKind, for switching on a result by value rather than by type
+type ResultKind int
+
+const (
+	SucceededKind ResultKind = iota
+	FailedKind
+	HaltKind
+)
+
+// String returns the name of the kind k
+func (k ResultKind) String() string {
+	switch k {
+	case SucceededKind:
+		return "succeeded"
+	case FailedKind:
+		return "failed"
+	case HaltKind:
+		return "halt"
+	}
+	return fmt.Sprintf("ResultKind(%d)", int(k))
 }
 
 // Result represents the result of applying a parser to an input text The
@@ -62,14 +152,20 @@ type Result interface {
 	// list of errors as returned by Errors()
 	Locs() locs
 
-	// value is the user determined value returned as
+	// Value is the user determined value returned as
 	// part of a successful parse result, or the value
 	// ultimately propagated back. The interpretation of
 	// this value is defined by the user, this could
 	// could be anything, e.g. an integer representing
 	// the result of an arithmetic expression, or an
 	// abstract syntax tree representing source code
-	value() any
+	Value() any
+
+	// WithValue returns the result with its value
+	// replaced by v, keeping its locations. Only a
+	// successful result carries a value, so for any
+	// other result WithValue returns it unchanged
+	WithValue(v any) Result
 
 	// Errors returns a list of errors or reasons for
 	// why the parser failed. If a Result contains a
@@ -77,6 +173,31 @@ type Result interface {
 	// it should contain an empty list of locs as
 	// returnd by Locs()
 	Errors() []parseError
+
+	// Kind returns the kind of the result, whether it
+	// succeeded, failed or halted
+	Kind() ResultKind
+}
+
+// valueStringer formats the values of succeeded results, see
+// SetValueStringer
+var valueStringer = func(v any) string {
+	return fmt.Sprint(v)
+}
+
+// SetValueStringer registers f as the function used to format the value
+// of a Succeeded result by its String method, so that the values a
+// grammar builds, such as the nodes of an abstract syntax tree, print
+// meaningfully. A nil f restores the default, fmt.Sprint. The stringer is
+// shared by every parse, so it should be registered before parsing
+// begins
+func SetValueStringer(f func(v any) string) {
+	if f == nil {
+		f = func(v any) string {
+			return fmt.Sprint(v)
+		}
+	}
+	valueStringer = f
 }
 
 // Succeeded implements the Result interface for a "successful" parse
@@ -90,18 +211,39 @@ type Succeeded struct {
 
 	// v is the user determined v returned by Value()
 	v any
+
+	// vals holds the value of the result at each of its
+	// locations once results with their own values are
+	// merged, or nil if v is the value at all of them
+	vals map[int]any
 }
 
 func NewSucceeded(s any, l int) Result {
-	return Succeeded{NewLocs(l), s}
+	return Succeeded{locs: NewLocs(l), v: s}
+}
+
+// NewSucceededLocs returns a Succeeded result with the value s which
+// finished at every location in the location set l, l should be
+// non-empty
+func NewSucceededLocs(s any, l locs) Result {
+	return Succeeded{locs: l, v: s}
 }
 
 // merge combines the Succeeded parse results a and b and their location
-// sets and internal values
+// sets and internal values, keeping the value each had at its own
+// locations, b's where both finished
 func (a Succeeded) merge(b Result) Result {
 	r := b.(Succeeded)
+	vals := make(map[int]any, len(a.locs)+len(r.locs))
+	for loc := range a.locs {
+		vals[loc] = a.ValueAt(loc)
+	}
+	for loc := range r.locs {
+		vals[loc] = r.ValueAt(loc)
+	}
 	a.locs = a.locs.Merge(r.locs)
 	a.v = r.v
+	a.vals = vals
 	return a
 }
 
@@ -112,18 +254,56 @@ func (s Succeeded) Locs() locs {
 	return s.locs
 }
 
-// value returns a user defined value returned as the result of a
+// Value returns a user defined value returned as the result of a
 // successful execution of a parser
-func (s Succeeded) value() any {
+func (s Succeeded) Value() any {
 	return s.v
 }
 
+// ValueAt returns the value of s at the location loc. This differs from
+// Value only when s was joined from results with values of their own,
+// Value then returns the value of the last of them
+func (s Succeeded) ValueAt(loc int) any {
+	if v, ok := s.vals[loc]; ok {
+		return v
+	}
+	return s.v
+}
+
+// WithValue returns a copy of s with the value v in place of its own at
+// every location, finishing at the same locations as s
+func (s Succeeded) WithValue(v any) Result {
+	s.v = v
+	s.vals = nil
+	return s
+}
+
 // Errors returns a list of errors or reasons for why the parser failed.
 // for A succueeded Result, the returned list will always be empty
 func (Succeeded) Errors() []parseError {
 	return nil
 }
 
+// String returns the value of s, formatted by the stringer registered
+// with SetValueStringer, and the locations at which s finished
+func (s Succeeded) String() string {
+	locs := make([]int, 0, len(s.locs))
+	for loc := range s.locs {
+		locs = append(locs, loc)
+	}
+	sort.Ints(locs)
+	ss := make([]string, len(locs))
+	for i, loc := range locs {
+		ss[i] = fmt.Sprint(loc)
+	}
+	return fmt.Sprintf("succeeded with %s at %s", valueStringer(s.v), strings.Join(ss, ", "))
+}
+
+// Kind returns SucceededKind
+func (Succeeded) Kind() ResultKind {
+	return SucceededKind
+}
+
 // Join joins the result b with the result a. This is just the result of
 // merging a and b if b is also a Succeeded result, or just a if b is a
 // Failed result
@@ -133,6 +313,8 @@ func (a Succeeded) Join(b Result) Result {
 		return a.merge(b)
 	case Failed:
 		return a
+	case Halt:
+		return b
 	}
 	panic(ErrInternal)
 }
@@ -154,6 +336,27 @@ func NewFailed(s string) Result {
 	}
 }
 
+// NewFailedAtEnd is like NewFailed but for a parser which failed at the
+// end of the input, the position end just after its last token, see
+// ParseError.AtEnd
+func NewFailedAtEnd(s string, end Pos) Result {
+	return Failed{
+		parseErrors: []parseError{
+			{expected: s, atEnd: true, end: end},
+		},
+	}
+}
+
+// NewFailedAt returns a Failed result with the single error message msg
+// positioned at pos in the input text
+func NewFailedAt(msg string, pos Pos) Result {
+	return Failed{
+		parseErrors: []parseError{
+			{message: msg, pos: pos},
+		},
+	}
+}
+
 // merge combines the Failed parse results a and b by merging their
 // parse errors
 func (a Failed) merge(b Result) Result {
@@ -169,19 +372,34 @@ func (Failed) Locs() locs {
 	return locs{}
 }
 
-// value usually returns a user defined value returned as the result of a
+// Value usually returns a user defined value returned as the result of a
 // successful execution of a parser. This will always be nil in this
 // case
-func (Failed) value() any {
+func (Failed) Value() any {
 	return nil
 }
 
+// WithValue returns f unchanged, a Failed result has no value
+func (f Failed) WithValue(any) Result {
+	return f
+}
+
 // Errors returns a list of errors or reasons for why the parser failed.
 // for A failed result, this will always be non-empty
 func (f Failed) Errors() []parseError {
 	return f.parseErrors
 }
 
+// String returns the messages of the errors of f
+func (f Failed) String() string {
+	return "failed: " + cutError(f.parseErrors).Error()
+}
+
+// Kind returns FailedKind
+func (Failed) Kind() ResultKind {
+	return FailedKind
+}
+
 // Join joins the result b with the result a. This is just the result of
 // merging a and b if b is also a Failed result, or just a if b is a
 // Succeed result
@@ -191,6 +409,124 @@ func (a Failed) Join(b Result) Result {
 		return b
 	case Failed:
 		return a.merge(b)
+	case Halt:
+		return b
 	}
 	panic(ErrInternal)
 }
+
+// Halt implements the Result interface for a parse which cannot go on.
+// Unlike Failed, a Halt is not a reason to try another alternative, it
+// indicates that something went wrong which no alternative can recover
+// from, e.g. a converter which could not convert the token text. A Halt
+// propagates through any chain it is joined to
+type Halt struct {
+	// component is the part of the parser which halted,
+	// e.g. "conversion"
+	component string
+
+	// err is the underlying reason for halting
+	err error
+
+	// pos is the position in the input text at which
+	// the parser halted
+	pos Pos
+}
+
+func NewHalt(component string, err error, pos Pos) Result {
+	return Halt{component, err, pos}
+}
+
+// Component returns the part of the parser which halted
+func (h Halt) Component() string {
+	return h.component
+}
+
+// Err returns the underlying reason for halting
+func (h Halt) Err() error {
+	return h.err
+}
+
+// Pos returns the position in the input text at which the parser halted
+func (h Halt) Pos() Pos {
+	return h.pos
+}
+
+// Error returns the message of the halt, including its position
+func (h Halt) Error() string {
+	return fmt.Sprintf("%s: %s: %v", h.pos, h.component, h.err)
+}
+
+// merge keeps the first of two halts, there is nothing meaningful in
+// combining the reasons for halting
+func (a Halt) merge(Result) Result {
+	return a
+}
+
+// Locs always returns an empty set of locations for a Halt
+func (Halt) Locs() locs {
+	return locs{}
+}
+
+// Value always returns nil for a Halt
+func (Halt) Value() any {
+	return nil
+}
+
+// WithValue returns h unchanged, a Halt has no value
+func (h Halt) WithValue(any) Result {
+	return h
+}
+
+// Errors returns a list containing the single reason for halting, or
+// for a cut the errors of the parse which failed after it
+func (h Halt) Errors() []parseError {
+	if errs, ok := h.err.(cutError); ok {
+		return errs
+	}
+	return []parseError{{message: h.Error()}}
+}
+
+// Kind returns HaltKind
+func (Halt) Kind() ResultKind {
+	return HaltKind
+}
+
+// Join always returns a, once a parse has halted the result of any
+// other parse is irrelevant
+func (a Halt) Join(Result) Result {
+	return a
+}
+
+// cutError is the reason for a Halt with the component "cut", the errors
+// of the parse which failed after the cut
+type cutError []parseError
+
+// Error returns the messages of the errors of e
+func (e cutError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// NewCut returns a Halt with the component "cut" positioned at pos,
+// carrying the errors of the Failed result r, for a parse which failed
+// after committing to an alternative. Unlike other halts, a cut only
+// propagates as far as the nearest enclosing M which is a barrier, see
+// M.Barrier, where it becomes an ordinary failure again
+func NewCut(r Result, pos Pos) Result {
+	return NewHalt("cut", cutError(r.Errors()), pos)
+}
+
+// Uncut returns the Failed result carried by r if r is a cut, see
+// NewCut, and otherwise r
+func Uncut(r Result) Result {
+	if h, ok := r.(Halt); ok {
+		if errs, ok := h.err.(cutError); ok {
+			return Failed{parseErrors: errs}
+		}
+	}
+	return r
+}
@@ -0,0 +1,96 @@
+package types
+
+// stream is a Tokeniser which carries the emit function of a call to
+// ParseStream, list producing parsers applied to a stream pass each
+// element to emit rather than collecting them
+type stream struct {
+	Tokeniser
+
+	// emit is called with the value of each element as
+	// it is recognised, or nil if elements are collected
+	// as usual
+	emit func(v any) error
+
+	// observe is called with the location at which each
+	// element began, if not nil
+	observe func(start int)
+}
+
+// Unwrap returns the Tokeniser the stream is parsed from
+func (s stream) Unwrap() Tokeniser {
+	return s.Tokeniser
+}
+
+// unstreamed is a Tokeniser which hides any stream beneath it, see
+// Unstream
+type unstreamed struct {
+	Tokeniser
+}
+
+// Unwrap returns the Tokeniser the stream is hidden from
+func (u unstreamed) Unwrap() Tokeniser {
+	return u.Tokeniser
+}
+
+// streamOf returns the stream t is or reads through, if any, stopping at
+// a Tokeniser which hides it
+func streamOf(t Tokeniser) (stream, bool) {
+	for {
+		switch u := t.(type) {
+		case stream:
+			return u, true
+		case unstreamed:
+			return stream{}, false
+		case Wrapper:
+			t = u.Unwrap()
+		default:
+			return stream{}, false
+		}
+	}
+}
+
+// ParseStream parses t like Parse but calls emit with the value of each
+// element of the list produced by m as it is recognised, rather than
+// collecting the elements into the value of the result. Only elements
+// of the outermost lists are emitted, the elements of lists nested
+// within an element are collected as usual. If emit returns an error the
+// parse stops, returning a Halt with the component "emit"
+func (m *M) ParseStream(t Tokeniser, emit func(v any) error) Result {
+	return m.Parse(stream{t, emit, nil})
+}
+
+// Observe returns a Tokeniser reading through t on which list producing
+// parsers call observe with the location at which each element of the
+// outermost list began, collecting the elements as usual. This lets a
+// parser of the list position an error at one of its elements
+func Observe(t Tokeniser, observe func(start int)) Tokeniser {
+	return stream{t, nil, observe}
+}
+
+// Emit passes v, the value of an element which began at the location
+// start, to the stream t is or reads through, ok reports whether the
+// stream emitted v at all, when it did not v should be collected as
+// usual
+func Emit(t Tokeniser, v any, start int) (ok bool, err error) {
+	s, ok := streamOf(t)
+	if !ok {
+		return
+	}
+	if s.observe != nil {
+		s.observe(start)
+	}
+	if s.emit == nil {
+		return false, nil
+	}
+	return true, s.emit(v)
+}
+
+// Unstream returns t with any stream it reads through hidden, or just t
+// if there is none. List producing parsers apply their elements to the
+// unstreamed Tokeniser so that nested lists are not emitted
+func Unstream(t Tokeniser) Tokeniser {
+	if _, ok := streamOf(t); ok {
+		return unstreamed{t}
+	}
+	return t
+}